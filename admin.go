@@ -0,0 +1,156 @@
+package main
+
+import (
+    "crypto/subtle"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+// AdminAuthenticator decides whether a request may use the /admin/ endpoints
+type AdminAuthenticator interface {
+    Authenticate(r *http.Request) bool
+}
+
+// bearerTokenAuthenticator accepts requests carrying "Authorization: Bearer <token>".
+// An empty token rejects every request.
+type bearerTokenAuthenticator struct {
+    token string
+}
+
+// Authenticate compares the bearer token in constant time
+func (a bearerTokenAuthenticator) Authenticate(r *http.Request) bool {
+    if a.token == "" {
+        return false
+    }
+    header := r.Header.Get("Authorization")
+    if !strings.HasPrefix(header, "Bearer ") {
+        return false
+    }
+    got := strings.TrimPrefix(header, "Bearer ")
+    return subtle.ConstantTimeCompare([]byte(got), []byte(a.token)) == 1
+}
+
+// adminMux holds the /admin/ routes, which are served behind requireAdmin
+var adminMux = http.NewServeMux()
+
+// requireAdmin wraps h so only authenticated requests reach it
+func requireAdmin(auth AdminAuthenticator, h http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if !auth.Authenticate(r) {
+            log.Printf("Rejected unauthenticated admin request %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+            w.Header().Set("WWW-Authenticate", "Bearer")
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+        h.ServeHTTP(w, r)
+    })
+}
+
+// reopenApology is posted on PRs reopened through /admin/reopen
+const reopenApology = "This pull request was closed automatically by commitvalidator by mistake and has been reopened. Sorry for the disruption."
+
+func init() {
+    adminMux.HandleFunc("/admin/reopen", reopenHandler)
+}
+
+// adminReopened holds the PRs reopened through /admin/reopen. closeFailedPR leaves them
+// open once, so the "reopened" webhook that follows doesn't close them again whatever
+// close_cooldown is set to. The mark is dropped by that delivery or the PR's next push or
+// close, after which failing validations close the PR as usual.
+var adminReopened = struct {
+    sync.Mutex
+    m map[string]bool
+}{m: make(map[string]bool)}
+
+// setAdminReopened marks or unmarks a PR as reopened by an admin
+func setAdminReopened(owner, repo string, prNumber int, reopened bool) {
+    adminReopened.Lock()
+    defer adminReopened.Unlock()
+    if reopened {
+        adminReopened.m[prKey(owner, repo, prNumber)] = true
+    } else {
+        delete(adminReopened.m, prKey(owner, repo, prNumber))
+    }
+}
+
+// takeAdminReopened reports whether an admin reopened the PR through /admin/reopen,
+// unmarking it
+func takeAdminReopened(owner, repo string, prNumber int) bool {
+    adminReopened.Lock()
+    defer adminReopened.Unlock()
+    key := prKey(owner, repo, prNumber)
+    reopened := adminReopened.m[key]
+    delete(adminReopened.m, key)
+    return reopened
+}
+
+// reopenHandler reopens every PR the validator closed within the window (the "window"
+// query parameter, else reopen_window) and hasn't reopened since, posting an apology on each
+func reopenHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "POST" {
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    window := config.ReopenWindow.Duration
+    if v := r.URL.Query().Get("window"); v != "" {
+        d, err := time.ParseDuration(v)
+        if err != nil {
+            http.Error(w, "Invalid window", http.StatusBadRequest)
+            return
+        }
+        window = d
+    }
+
+    // Replay the window in order so a reopen cancels an earlier close of the same PR
+    closed := make(map[string]auditEntry)
+    var order []string
+    for _, e := range audit.Since(time.Now().Add(-window)) {
+        key := fmt.Sprintf("%s#%d", e.Repo, e.PR)
+        switch e.Kind {
+        case auditClose:
+            if _, ok := closed[key]; !ok {
+                order = append(order, key)
+            }
+            closed[key] = e
+        case auditReopen:
+            delete(closed, key)
+        }
+    }
+
+    var result struct {
+        Reopened []string          `json:"reopened"`
+        Failed   map[string]string `json:"failed,omitempty"`
+    }
+    result.Reopened = []string{}
+    for _, key := range order {
+        e, ok := closed[key]
+        if !ok {
+            continue
+        }
+        owner, repo, _ := strings.Cut(e.Repo, "/")
+        // Mark the PR first: the "reopened" webhook can arrive before reopenPullRequest returns
+        setAdminReopened(owner, repo, e.PR, true)
+        if err := githubAPI.ReopenPR(r.Context(), owner, repo, e.PR); err != nil {
+            setAdminReopened(owner, repo, e.PR, false)
+            log.Printf("Could not reopen PR %s: %v", key, err)
+            if result.Failed == nil {
+                result.Failed = make(map[string]string)
+            }
+            result.Failed[key] = err.Error()
+            continue
+        }
+        audit.Record(auditEntry{Kind: auditReopen, Repo: e.Repo, PR: e.PR, Detail: "admin reopen"})
+        if err := githubAPI.PostComment(r.Context(), owner, repo, e.PR, reopenApology); err != nil {
+            log.Printf("Could not post apology on PR %s: %v", key, err)
+        }
+        log.Printf("Reopened PR %s closed at %s", key, e.Time.Format(time.RFC3339))
+        result.Reopened = append(result.Reopened, key)
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "time"
+)
+
+// sendAlert logs an operator alert and, when ALERT_WEBHOOK_URL is set, posts it
+// there as a Slack-compatible {"text": ...} message
+func sendAlert(text string) {
+    log.Printf("ALERT: %s", text)
+    url := os.Getenv("ALERT_WEBHOOK_URL")
+    if url == "" {
+        return
+    }
+    if err := postChatMessage(url, text); err != nil {
+        log.Printf("Could not send alert: %v", err)
+    }
+}
+
+// postChatMessage posts text to a Slack-compatible incoming webhook
+func postChatMessage(url, text string) error {
+    body, _ := json.Marshal(map[string]string{"text": text})
+    client := &http.Client{Timeout: 10 * time.Second}
+    resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode >= 300 {
+        return fmt.Errorf("webhook returned %s", resp.Status)
+    }
+    return nil
+}
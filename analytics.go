@@ -0,0 +1,124 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+)
+
+// validationEvent is the analytics record emitted for each validation
+type validationEvent struct {
+    Time     time.Time   `json:"time"`
+    ReportID string      `json:"report_id"`
+    Repo     string      `json:"repo"`
+    PR       int         `json:"pr"`
+    Profile  string      `json:"profile,omitempty"`
+    Status   string      `json:"status"`
+    Rules    []ruleEvent `json:"rules"`
+}
+
+// ruleEvent is one rule's outcome within a validationEvent
+type ruleEvent struct {
+    Name       string  `json:"name"`
+    Result     string  `json:"result"`
+    DurationMS float64 `json:"duration_ms"`
+}
+
+// analyticsEvents queues events for the sink; nil when analytics are off
+var analyticsEvents chan validationEvent
+
+// startAnalyticsSink starts delivering events to spec, which is "stdout",
+// "file:<path>" or an http(s) URL that events are POSTed to
+func startAnalyticsSink(spec string) error {
+    var write func([]byte) error
+    switch {
+    case spec == "stdout":
+        write = func(line []byte) error {
+            _, err := os.Stdout.Write(line)
+            return err
+        }
+    case strings.HasPrefix(spec, "file:"):
+        f, err := os.OpenFile(strings.TrimPrefix(spec, "file:"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+        if err != nil {
+            return err
+        }
+        write = func(line []byte) error {
+            _, err := f.Write(line)
+            return err
+        }
+    case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+        client := &http.Client{Timeout: 10 * time.Second}
+        write = func(line []byte) error {
+            resp, err := client.Post(spec, "application/json", bytes.NewReader(line))
+            if err != nil {
+                return err
+            }
+            defer resp.Body.Close()
+            io.Copy(io.Discard, resp.Body)
+            if resp.StatusCode >= 300 {
+                return fmt.Errorf("analytics sink returned %s", resp.Status)
+            }
+            return nil
+        }
+    default:
+        return fmt.Errorf("unsupported analytics sink %q", spec)
+    }
+    analyticsEvents = make(chan validationEvent, 100)
+    go func() {
+        for ev := range analyticsEvents {
+            line, err := json.Marshal(ev)
+            if err != nil {
+                log.Printf("Could not encode analytics event: %v", err)
+                continue
+            }
+            if err := write(append(line, '\n')); err != nil {
+                log.Printf("Could not deliver analytics event for %s#%d: %v", ev.Repo, ev.PR, err)
+            }
+        }
+    }()
+    return nil
+}
+
+// emitValidationEvent queues an analytics event without blocking, dropping it if the sink is backed up
+func emitValidationEvent(owner, repo string, prNumber int, reportID, profile, status string, results []ruleResult) {
+    if analyticsEvents == nil {
+        return
+    }
+    ev := validationEvent{
+        Time:     time.Now().UTC(),
+        ReportID: reportID,
+        Repo:     owner + "/" + repo,
+        PR:       prNumber,
+        Profile:  profile,
+        Status:   status,
+    }
+    for _, res := range results {
+        result := "pass"
+        switch {
+        case res.Skipped:
+            result = "skipped"
+        case res.Err != nil:
+            result = "error"
+        case len(res.Failures) > 0:
+            result = "fail"
+        case len(res.Pending) > 0:
+            result = "pending"
+        }
+        ev.Rules = append(ev.Rules, ruleEvent{
+            Name:       res.Rule,
+            Result:     result,
+            DurationMS: float64(res.Duration) / float64(time.Millisecond),
+        })
+    }
+    select {
+    case analyticsEvents <- ev:
+    default:
+        log.Printf("Analytics sink is backed up, dropping event for %s#%d", ev.Repo, ev.PR)
+    }
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "sort"
+    "strings"
+)
+
+// App represents an app config in apps.json
+type App struct {
+    Name            string   `json:"name"`
+    CMDBWhitelists  []map[string]string `json:"cmdb_whitelists"`
+    CMDBBlacklists  []map[string]string `json:"cmdb_blacklists"`
+    Whitelists      []string `json:"whitelists"`
+    Blacklists      []string `json:"blacklists"`
+}
+
+// AppsJson represents the structure of apps.json
+type AppsJson struct {
+    SchemaVersion string `json:"schema_version,omitempty"`
+    Apps          []App  `json:"apps"`
+}
+
+// appsJsonPath is the local apps.json (APPS_JSON_PATH) used when a copy can't be fetched from GitHub
+var appsJsonPath = "./apps.json"
+
+// Helper to compare two App configs
+func appConfigEqual(a, b App) bool {
+    aBytes, _ := json.Marshal(a)
+    bBytes, _ := json.Marshal(b)
+    return bytes.Equal(aBytes, bBytes)
+}
+
+// modifiedApps returns the apps in pr that are new or configured differently than in base
+func modifiedApps(pr, base *AppsJson) []App {
+    baseApps := make(map[string]App)
+    if base != nil {
+        for _, app := range base.Apps {
+            baseApps[app.Name] = app
+        }
+    }
+    var modified []App
+    for _, app := range pr.Apps {
+        if baseApp, ok := baseApps[app.Name]; !ok || !appConfigEqual(app, baseApp) {
+            modified = append(modified, app)
+        }
+    }
+    return modified
+}
+
+// whitelistedServers returns an app's whitelists plus its resolved cmdb_whitelists
+func whitelistedServers(ctx context.Context, app App) ([]string, error) {
+    servers := append([]string{}, app.Whitelists...)
+    for _, m := range app.CMDBWhitelists {
+        resolved, err := resolveCMDBEntry(ctx, m)
+        if err != nil {
+            return nil, err
+        }
+        servers = append(servers, resolved...)
+    }
+    return servers, nil
+}
+
+// computeImpactedServers returns the servers an app targets: its whitelists minus its
+// blacklists, with CMDB entries resolved. It also returns the cmdb_whitelists entries
+// that resolved to no servers.
+func computeImpactedServers(ctx context.Context, app App) (map[string]bool, []string, error) {
+    impactedServers := make(map[string]bool)
+    var emptyQueries []string
+    for _, s := range app.Whitelists {
+        impactedServers[s] = true
+    }
+    for _, m := range app.CMDBWhitelists {
+        servers, err := resolveCMDBEntry(ctx, m)
+        if err != nil {
+            return nil, nil, err
+        }
+        if len(servers) == 0 {
+            emptyQueries = append(emptyQueries, cmdbEntryString(m))
+        }
+        for _, v := range servers {
+            impactedServers[v] = true
+        }
+    }
+    for _, s := range app.Blacklists {
+        delete(impactedServers, s)
+    }
+    for _, m := range app.CMDBBlacklists {
+        servers, err := resolveCMDBEntry(ctx, m)
+        if err != nil {
+            return nil, nil, err
+        }
+        for _, v := range servers {
+            delete(impactedServers, v)
+        }
+    }
+    return impactedServers, emptyQueries, nil
+}
+
+// staticImpactedServers returns an app's whitelists minus its blacklists, ignoring CMDB entries
+func staticImpactedServers(app App) map[string]bool {
+    impactedServers := make(map[string]bool)
+    for _, s := range app.Whitelists {
+        impactedServers[s] = true
+    }
+    for _, s := range app.Blacklists {
+        delete(impactedServers, s)
+    }
+    return impactedServers
+}
+
+// addedWhitelistEntries returns each app's whitelists entries in pr that base doesn't have, in order
+func addedWhitelistEntries(pr, base *AppsJson) map[string][]string {
+    existing := make(map[string]map[string]bool)
+    if base != nil {
+        for _, app := range base.Apps {
+            existing[app.Name] = make(map[string]bool)
+            for _, s := range app.Whitelists {
+                existing[app.Name][s] = true
+            }
+        }
+    }
+    added := make(map[string][]string)
+    for _, app := range pr.Apps {
+        for _, s := range app.Whitelists {
+            if !existing[app.Name][s] {
+                added[app.Name] = append(added[app.Name], s)
+            }
+        }
+    }
+    return added
+}
+
+// removedWhitelistEntries returns each app's whitelists entries in base that pr no longer has, in order
+func removedWhitelistEntries(pr, base *AppsJson) map[string][]string {
+    remaining := make(map[string]map[string]bool)
+    for _, app := range pr.Apps {
+        remaining[app.Name] = make(map[string]bool)
+        for _, s := range app.Whitelists {
+            remaining[app.Name][s] = true
+        }
+    }
+    removed := make(map[string][]string)
+    if base == nil {
+        return removed
+    }
+    for _, app := range base.Apps {
+        for _, s := range app.Whitelists {
+            if !remaining[app.Name][s] {
+                removed[app.Name] = append(removed[app.Name], s)
+            }
+        }
+    }
+    return removed
+}
+
+// splitAppPath splits a changed file path into its app, module and the remaining path within
+// the module. Paths with fewer than 3 segments belong to no module and report ok false.
+func splitAppPath(name string) (app, module, file string, ok bool) {
+    parts := strings.SplitN(name, "/", 3)
+    if len(parts) < 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+        return "", "", "", false
+    }
+    return parts[0], parts[1], parts[2], true
+}
+
+// sortedKeys returns the keys of a set in sorted order
+func sortedKeys(set map[string]bool) []string {
+    keys := make([]string, 0, len(set))
+    for k := range set {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// serverEnv extracts a server's environment using the configured env regex
+func serverEnv(server string) string {
+    if config.serverEnvRe == nil {
+        return ""
+    }
+    m := config.serverEnvRe.FindStringSubmatch(server)
+    if m == nil {
+        return ""
+    }
+    if i := config.serverEnvRe.SubexpIndex("env"); i > 0 {
+        return m[i]
+    }
+    if len(m) > 1 {
+        return m[1]
+    }
+    return ""
+}
+
+// isProdServer reports whether a server's extracted environment is one of the prod environments
+func isProdServer(server string) bool {
+    env := serverEnv(server)
+    for _, p := range config.ProdEnvs {
+        if env == p {
+            return true
+        }
+    }
+    return false
+}
+
+// checkProdImpact returns a violation when more prod servers are impacted than max_prod_impact allows
+func checkProdImpact(prodServers map[string]bool, labels []Label) string {
+    if config.MaxProdImpact <= 0 || len(prodServers) <= config.MaxProdImpact {
+        return ""
+    }
+    var servers []string
+    for s := range prodServers {
+        servers = append(servers, s)
+    }
+    sort.Strings(servers)
+    if config.ProdImpactOverrideLabel != "" && hasLabel(labels, config.ProdImpactOverrideLabel) {
+        log.Printf("PR impacts %d prod servers (limit %d), allowed by label %q: %s", len(servers), config.MaxProdImpact, config.ProdImpactOverrideLabel, strings.Join(servers, ", "))
+        return ""
+    }
+    v := fmt.Sprintf("PR impacts %d prod servers, limit is %d: %s", len(servers), config.MaxProdImpact, strings.Join(servers, ", "))
+    if config.ProdImpactOverrideLabel != "" {
+        v += fmt.Sprintf(" (add the %q label to override)", config.ProdImpactOverrideLabel)
+    }
+    return v
+}
+
+// checkSchemaVersion returns a violation if apps.json doesn't declare the expected schema_version
+func checkSchemaVersion(appsJson AppsJson, expected string) string {
+    if expected == "" || appsJson.SchemaVersion == expected {
+        return ""
+    }
+    if appsJson.SchemaVersion == "" {
+        return fmt.Sprintf("apps.json is missing schema_version, add \"schema_version\": %q at the top level", expected)
+    }
+    return fmt.Sprintf("apps.json declares schema_version %q, expected %q; update the file to the current format", appsJson.SchemaVersion, expected)
+}
+
+// checkAppNameUniqueness returns a violation for each app defined in more than one app-config file
+func checkAppNameUniqueness(appsFiles map[string]AppsJson) []string {
+    var paths []string
+    for path := range appsFiles {
+        paths = append(paths, path)
+    }
+    sort.Strings(paths)
+    definedIn := make(map[string][]string)
+    for _, path := range paths {
+        seen := make(map[string]bool)
+        for _, app := range appsFiles[path].Apps {
+            if !seen[app.Name] {
+                seen[app.Name] = true
+                definedIn[app.Name] = append(definedIn[app.Name], path)
+            }
+        }
+    }
+    var names []string
+    for name, files := range definedIn {
+        if len(files) > 1 {
+            names = append(names, name)
+        }
+    }
+    sort.Strings(names)
+    var violations []string
+    for _, name := range names {
+        violations = append(violations, fmt.Sprintf("app %q is defined in multiple app-config files: %s", name, strings.Join(definedIn[name], ", ")))
+    }
+    return violations
+}
+
+// checkListConflicts returns a violation for each server or CMDB entry an app both whitelists and
+// blacklists, and for each app name defined more than once
+func checkListConflicts(appsJson AppsJson) []string {
+    var violations []string
+    counts := make(map[string]int)
+    for _, app := range appsJson.Apps {
+        counts[app.Name]++
+        blacklisted := make(map[string]bool)
+        for _, s := range app.Blacklists {
+            blacklisted[s] = true
+        }
+        for _, s := range app.Whitelists {
+            if blacklisted[s] {
+                violations = append(violations, fmt.Sprintf("app %q lists server %s in both whitelists and blacklists", app.Name, s))
+            }
+        }
+        cmdbBlacklisted := make(map[string]bool)
+        for _, m := range app.CMDBBlacklists {
+            cmdbBlacklisted[cmdbEntryString(m)] = true
+        }
+        for _, m := range app.CMDBWhitelists {
+            if entry := cmdbEntryString(m); cmdbBlacklisted[entry] {
+                violations = append(violations, fmt.Sprintf("app %q lists CMDB entry %s in both cmdb_whitelists and cmdb_blacklists", app.Name, entry))
+            }
+        }
+    }
+    var duplicates []string
+    for name, n := range counts {
+        if n > 1 {
+            duplicates = append(duplicates, name)
+        }
+    }
+    sort.Strings(duplicates)
+    for _, name := range duplicates {
+        violations = append(violations, fmt.Sprintf("app %q is defined %d times in apps.json", name, counts[name]))
+    }
+    return violations
+}
+
+// validateAppsJsonSchema checks raw apps.json content for required fields and value types, which
+// json.Unmarshal would otherwise zero or skip, and returns one message per problem naming its path
+func validateAppsJsonSchema(data []byte) []string {
+    var doc interface{}
+    if err := json.Unmarshal(data, &doc); err != nil {
+        return []string{fmt.Sprintf("apps.json is not valid JSON: %v", err)}
+    }
+    root, ok := doc.(map[string]interface{})
+    if !ok {
+        return []string{fmt.Sprintf("apps.json: expected an object, got %s", jsonType(doc))}
+    }
+    var errs []string
+    if v, ok := root["schema_version"]; ok {
+        if _, ok := v.(string); !ok {
+            errs = append(errs, fmt.Sprintf("apps.json schema_version: expected string, got %s", jsonType(v)))
+        }
+    }
+    apps, ok := root["apps"].([]interface{})
+    if !ok {
+        if _, present := root["apps"]; !present {
+            return append(errs, "apps.json apps: required field is missing")
+        }
+        return append(errs, fmt.Sprintf("apps.json apps: expected array, got %s", jsonType(root["apps"])))
+    }
+    for i, a := range apps {
+        path := fmt.Sprintf("apps[%d]", i)
+        app, ok := a.(map[string]interface{})
+        if !ok {
+            errs = append(errs, fmt.Sprintf("apps.json %s: expected object, got %s", path, jsonType(a)))
+            continue
+        }
+        if name, ok := app["name"].(string); !ok {
+            if _, present := app["name"]; !present {
+                errs = append(errs, fmt.Sprintf("apps.json %s.name: required field is missing", path))
+            } else {
+                errs = append(errs, fmt.Sprintf("apps.json %s.name: expected string, got %s", path, jsonType(app["name"])))
+            }
+        } else if strings.TrimSpace(name) == "" {
+            errs = append(errs, fmt.Sprintf("apps.json %s.name: must not be empty", path))
+        }
+        for _, field := range []string{"whitelists", "blacklists"} {
+            v, present := app[field]
+            if !present {
+                continue
+            }
+            list, ok := v.([]interface{})
+            if !ok {
+                errs = append(errs, fmt.Sprintf("apps.json %s.%s: expected array, got %s", path, field, jsonType(v)))
+                continue
+            }
+            for j, item := range list {
+                if _, ok := item.(string); !ok {
+                    errs = append(errs, fmt.Sprintf("apps.json %s.%s[%d]: expected string, got %s", path, field, j, jsonType(item)))
+                }
+            }
+        }
+        for _, field := range []string{"cmdb_whitelists", "cmdb_blacklists"} {
+            v, present := app[field]
+            if !present {
+                continue
+            }
+            list, ok := v.([]interface{})
+            if !ok {
+                errs = append(errs, fmt.Sprintf("apps.json %s.%s: expected array, got %s", path, field, jsonType(v)))
+                continue
+            }
+            for j, item := range list {
+                entry, ok := item.(map[string]interface{})
+                if !ok {
+                    errs = append(errs, fmt.Sprintf("apps.json %s.%s[%d]: expected object, got %s", path, field, j, jsonType(item)))
+                    continue
+                }
+                var keys []string
+                for k := range entry {
+                    keys = append(keys, k)
+                }
+                sort.Strings(keys)
+                for _, k := range keys {
+                    if _, ok := entry[k].(string); !ok {
+                        errs = append(errs, fmt.Sprintf("apps.json %s.%s[%d].%s: expected string, got %s", path, field, j, k, jsonType(entry[k])))
+                    }
+                }
+            }
+        }
+    }
+    return errs
+}
+
+// jsonType names the JSON type of a value decoded into an interface{}
+func jsonType(v interface{}) string {
+    switch v.(type) {
+    case nil:
+        return "null"
+    case bool:
+        return "boolean"
+    case float64:
+        return "number"
+    case string:
+        return "string"
+    case []interface{}:
+        return "array"
+    case map[string]interface{}:
+        return "object"
+    }
+    return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "io/ioutil"
+    "log"
+    "net/http"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+// useConfig sets config for the rest of the test, giving rules a timeout as loadConfig would
+func useConfig(t *testing.T, c Config) {
+    t.Helper()
+    if c.RuleTimeout.Duration == 0 {
+        c.RuleTimeout.Duration = 5 * time.Second
+    }
+    saved := config
+    config = c
+    t.Cleanup(func() { config = saved })
+}
+
+func TestAppsJsonPathFallbackForBase(t *testing.T) {
+    useConfig(t, Config{})
+    mockGitHub(t, func(w http.ResponseWriter, r *http.Request) {
+        http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+    })
+    path := filepath.Join(t.TempDir(), "apps.json")
+    if err := ioutil.WriteFile(path, []byte(`{"apps":[{"name":"billing","whitelists":["web1"]}]}`), 0644); err != nil {
+        t.Fatal(err)
+    }
+    defer func(p string) { appsJsonPath = p }(appsJsonPath)
+    appsJsonPath = path
+
+    pc := &prContext{
+        Owner: "o", Repo: "r", Number: 1, Action: "opened",
+        AppServers:      make(map[string]map[string]bool),
+        BaseAppServers:  make(map[string]map[string]bool),
+        ImpactedServers: make(map[string]bool),
+        ProdServers:     make(map[string]bool),
+        Files: []PRFile{{Filename: "apps.json", Status: "modified", Patch: `@@ -1 +1 @@
+-{"apps":[{"name":"billing","whitelists":["web1"]}]}
++{"apps":[{"name":"billing","whitelists":["web1","web2"]}]}`}},
+        // GitHub has the PR head but can't provide apps.json at the base
+        fetchFile: func(ctx context.Context, path, ref string) ([]byte, error) {
+            if ref == "refs/pull/1/head" {
+                return []byte(`{"apps":[{"name":"billing","whitelists":["web1","web2"]}]}`), nil
+            }
+            return nil, errors.New("unavailable")
+        },
+    }
+    var rep bytes.Buffer
+    validateChanges(context.Background(), pc, &rep, log.New(ioutil.Discard, "", 0))
+
+    if pc.BaseAppsJson == nil || len(pc.BaseAppsJson.Apps) != 1 {
+        t.Fatalf("base apps.json not read from %s: %+v", path, pc.BaseAppsJson)
+    }
+    if !pc.ImpactedServers["web1"] || !pc.ImpactedServers["web2"] {
+        t.Errorf("impacted servers = %v, want web1 and web2", pc.ImpactedServers)
+    }
+    if strings.Contains(rep.String(), "Skipping impacted servers") {
+        t.Errorf("impacted servers skipped despite the fallback:\n%s", rep.String())
+    }
+}
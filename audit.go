@@ -0,0 +1,98 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "log"
+    "os"
+    "sync"
+    "time"
+)
+
+// auditEntry records an action the validator took on a PR
+type auditEntry struct {
+    Time   time.Time `json:"time"`
+    Kind   string    `json:"kind"`
+    Repo   string    `json:"repo"`
+    PR     int       `json:"pr"`
+    Detail string    `json:"detail,omitempty"`
+}
+
+// Audit entry kinds
+const (
+    auditClose       = "close"
+    auditCloseFailed = "close_failed"
+    auditReopen      = "reopen"
+)
+
+// auditStore keeps the most recent audit entries in memory and, when a file is
+// configured, appends every entry to it as a JSON line
+type auditStore struct {
+    mu      sync.Mutex
+    entries []auditEntry
+    max     int
+    file    *os.File
+}
+
+// audit is the process-wide audit store
+var audit = &auditStore{max: 10000}
+
+// open loads the existing entries from path and appends new ones to it
+func (a *auditStore) open(path string) error {
+    f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+    if err != nil {
+        return err
+    }
+    scanner := bufio.NewScanner(f)
+    scanner.Buffer(make([]byte, 64*1024), 1<<20)
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    for scanner.Scan() {
+        var e auditEntry
+        if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+            continue
+        }
+        a.append(e)
+    }
+    if err := scanner.Err(); err != nil {
+        f.Close()
+        return err
+    }
+    a.file = f
+    return nil
+}
+
+// append adds e to the in-memory entries, dropping the oldest past max. Callers hold mu.
+func (a *auditStore) append(e auditEntry) {
+    a.entries = append(a.entries, e)
+    if len(a.entries) > a.max {
+        a.entries = a.entries[len(a.entries)-a.max:]
+    }
+}
+
+// Record adds an entry, stamping it with the current time
+func (a *auditStore) Record(e auditEntry) {
+    e.Time = time.Now().UTC()
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    a.append(e)
+    if a.file != nil {
+        line, _ := json.Marshal(e)
+        if _, err := a.file.Write(append(line, '\n')); err != nil {
+            log.Printf("Could not write audit entry: %v", err)
+        }
+    }
+}
+
+// Since returns the entries recorded at or after t, oldest first
+func (a *auditStore) Since(t time.Time) []auditEntry {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    var out []auditEntry
+    for _, e := range a.entries {
+        if !e.Time.Before(t) {
+            out = append(out, e)
+        }
+    }
+    return out
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// useChecksAPI reports results as a check run rather than a commit status. Check runs
+// can only be created with a GitHub App installation token.
+var useChecksAPI bool
+
+// maxCheckSummary is the largest output.summary GitHub accepts for a check run
+const maxCheckSummary = 65535
+
+// maxCheckAnnotations is how many annotations GitHub accepts in one check run request
+const maxCheckAnnotations = 50
+
+// checkAnnotation points a failure at a file; Line is 0 when the line isn't known
+type checkAnnotation struct {
+    Path    string
+    Line    int
+    Rule    string
+    Message string
+}
+
+// postCheckRun creates a completed commitvalidator check run on sha, or an in-progress one
+// for pending results, with title, summary and annotations as its output
+func postCheckRun(ctx context.Context, owner, repo, sha, state, title, summary string, annotations []checkAnnotation) error {
+    output := map[string]interface{}{
+        "title":   title,
+        "summary": summary,
+    }
+    var list []map[string]interface{}
+    for i, a := range annotations {
+        if i == maxCheckAnnotations {
+            break
+        }
+        // GitHub requires a line, so unknown lines point at the top of the file
+        line := a.Line
+        if line < 1 {
+            line = 1
+        }
+        list = append(list, map[string]interface{}{
+            "path":             a.Path,
+            "start_line":       line,
+            "end_line":         line,
+            "annotation_level": "failure",
+            "title":            a.Rule,
+            "message":          a.Message,
+        })
+    }
+    if len(list) > 0 {
+        output["annotations"] = list
+    }
+    body := map[string]interface{}{
+        "name":     "commitvalidator",
+        "head_sha": sha,
+        "output":   output,
+    }
+    if state == "pending" {
+        body["status"] = "in_progress"
+    } else {
+        body["status"] = "completed"
+        body["conclusion"] = state
+    }
+    req, err := githubRequest(ctx, "POST", fmt.Sprintf(githubAPIBase+"/repos/%s/%s/check-runs", owner, repo), body)
+    if err != nil {
+        return err
+    }
+    return githubSend(req, nil, 201)
+}
+
+// impactSummary renders the impacted servers of each app as a markdown table for a check
+// run summary, dropping rows that would exceed maxCheckSummary
+func impactSummary(appServers map[string]map[string]bool) string {
+    if len(appServers) == 0 {
+        return "No apps impacted by apps.json changes."
+    }
+    apps := make([]string, 0, len(appServers))
+    for app := range appServers {
+        apps = append(apps, app)
+    }
+    sort.Strings(apps)
+    var b strings.Builder
+    b.WriteString("| App | Impacted servers |\n| --- | --- |\n")
+    for i, app := range apps {
+        servers := sortedKeys(appServers[app])
+        row := fmt.Sprintf("| %s | %s |\n", app, strings.Join(servers, ", "))
+        // Leave room for the truncation note
+        if b.Len()+len(row) > maxCheckSummary-100 {
+            fmt.Fprintf(&b, "\n_Truncated: %d of %d apps shown._\n", i, len(apps))
+            break
+        }
+        b.WriteString(row)
+    }
+    return b.String()
+}
+
+// failureAnnotations annotates each changed file named in a rule failure or file violation
+func failureAnnotations(files []PRFile, results []ruleResult, fileViolations []string) []checkAnnotation {
+    var annotations []checkAnnotation
+    add := func(rule, msg string) {
+        for _, f := range files {
+            if mentionsPath(msg, f.Filename) {
+                annotations = append(annotations, checkAnnotation{Path: f.Filename, Rule: rule, Message: msg})
+            }
+        }
+    }
+    for _, res := range results {
+        for _, msg := range res.Failures {
+            add(res.Rule, msg)
+        }
+    }
+    for _, msg := range fileViolations {
+        add("file-rules", msg)
+    }
+    return annotations
+}
+
+// mentionsPath reports whether msg names path as a whole path, so "app/a.yaml" isn't
+// matched inside "app/a.yaml.bak" or "other/app/a.yaml"
+func mentionsPath(msg, path string) bool {
+    for i := 0; path != ""; {
+        j := strings.Index(msg[i:], path)
+        if j < 0 {
+            return false
+        }
+        start, end := i+j, i+j+len(path)
+        before := start == 0 || !isPathByte(msg[start-1])
+        // A period right after the path ends the sentence rather than extending the name
+        after := end == len(msg) || !isPathByte(msg[end]) ||
+            (msg[end] == '.' && (end+1 == len(msg) || msg[end+1] == ' '))
+        if before && after {
+            return true
+        }
+        i = start + 1
+    }
+    return false
+}
+
+// isPathByte reports whether c can be part of a repo path
+func isPathByte(c byte) bool {
+    return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("/._-+@~", c) >= 0
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestFailureAnnotationsMatchWholePaths(t *testing.T) {
+    files := []PRFile{{Filename: "app/a.yaml"}, {Filename: "app/a.yaml.bak"}, {Filename: "a.yaml"}}
+    results := []ruleResult{{Rule: "r", Failures: []string{"app/a.yaml.bak is not allowed"}}}
+    violations := []string{"app/a.yaml adds 900 lines, limit is 500", "Remove a.yaml."}
+
+    got := make(map[string][]string)
+    for _, a := range failureAnnotations(files, results, violations) {
+        got[a.Path] = append(got[a.Path], a.Message)
+    }
+    want := map[string]string{
+        "app/a.yaml.bak": "app/a.yaml.bak is not allowed",
+        "app/a.yaml":     "app/a.yaml adds 900 lines, limit is 500",
+        "a.yaml":         "Remove a.yaml.",
+    }
+    for path, msg := range want {
+        if len(got[path]) != 1 || got[path][0] != msg {
+            t.Errorf("%s annotated with %q, want only %q", path, got[path], msg)
+        }
+    }
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+    "bytes"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "net/http/httptest"
+    "os"
+    "regexp"
+    "strconv"
+    "strings"
+)
+
+// prRefRe matches a PR reference like owner/repo#123
+var prRefRe = regexp.MustCompile(`^([^/\s]+)/([^#\s]+)#(\d+)$`)
+
+// runCLI handles `commitvalidator validate [--github-summary] owner/repo#N`: it runs the
+// webhook flow for one PR as if it had just been opened and returns the process exit code,
+// 1 when validation fails. With --github-summary the report is also appended as markdown to
+// the file named by GITHUB_STEP_SUMMARY, for GitHub Actions job summaries.
+func runCLI(args []string) int {
+    usage := func() int {
+        fmt.Fprintf(os.Stderr, "usage: %s validate [--github-summary] owner/repo#N\n", os.Args[0])
+        return 2
+    }
+    if args[0] != "validate" {
+        return usage()
+    }
+    fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+    githubSummary := fs.Bool("github-summary", false, "append the report as markdown to the file in GITHUB_STEP_SUMMARY")
+    if err := fs.Parse(args[1:]); err != nil || fs.NArg() != 1 {
+        return usage()
+    }
+    m := prRefRe.FindStringSubmatch(fs.Arg(0))
+    if m == nil {
+        return usage()
+    }
+    owner, repo := m[1], m[2]
+    number, _ := strconv.Atoi(m[3])
+
+    payload, _ := json.Marshal(map[string]interface{}{
+        "action": "opened",
+        "number": number,
+        "repository": map[string]interface{}{
+            "name":  repo,
+            "owner": map[string]string{"login": owner},
+        },
+    })
+    req := httptest.NewRequest("POST", "/webhook", bytes.NewReader(payload))
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("X-GitHub-Event", "pull_request")
+    // The handler rejects unsigned deliveries when WEBHOOK_SECRET is set; sign like GitHub would
+    if webhookSecret != "" {
+        mac := hmac.New(sha256.New, []byte(webhookSecret))
+        mac.Write(payload)
+        req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+    }
+    rec := httptest.NewRecorder()
+    prWebhookHandler(rec, req)
+    report := rec.Body.String()
+    fmt.Print(report)
+
+    if *githubSummary {
+        path := os.Getenv("GITHUB_STEP_SUMMARY")
+        if path == "" {
+            fmt.Fprintln(os.Stderr, "--github-summary needs GITHUB_STEP_SUMMARY to be set")
+            return 2
+        }
+        f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Could not open GITHUB_STEP_SUMMARY: %v\n", err)
+            return 2
+        }
+        fmt.Fprintf(f, "### commitvalidator: %s/%s#%d\n\n```\n%s\n```\n", owner, repo, number, strings.TrimRight(report, "\n"))
+        if err := f.Close(); err != nil {
+            fmt.Fprintf(os.Stderr, "Could not write GITHUB_STEP_SUMMARY: %v\n", err)
+            return 2
+        }
+    }
+    if strings.Contains(report, "Status: failure") {
+        return 1
+    }
+    return 0
+}
@@ -0,0 +1,116 @@
+package main
+
+import "context"
+
+// GitHubClient is the GitHub API surface the webhook handler and rules depend on, so the
+// validation flow can run against something other than the live API
+type GitHubClient interface {
+    FetchPRFiles(ctx context.Context, owner, repo string, prNumber int) ([]PRFile, error)
+    FetchPRDetails(ctx context.Context, owner, repo string, prNumber int) (*PRDetails, error)
+    FetchPRDiff(ctx context.Context, owner, repo string, prNumber int) (string, error)
+    FetchPRCommits(ctx context.Context, owner, repo string, prNumber int) ([]Commit, error)
+    FetchPRReviews(ctx context.Context, owner, repo string, prNumber int) ([]Review, error)
+    FetchTree(ctx context.Context, owner, repo, sha string) (map[string]bool, error)
+    FetchFileContent(ctx context.Context, owner, repo, path, ref string) ([]byte, error)
+    CompareCommits(ctx context.Context, owner, repo, base, head string) (*Comparison, error)
+    PathHasHistory(ctx context.Context, owner, repo, path, ref string) (bool, error)
+    IsOrgMember(ctx context.Context, org, user string) (bool, error)
+    IsTeamMember(ctx context.Context, org, team, user string) (bool, error)
+
+    UpdateStatus(ctx context.Context, owner, repo string, prNumber int, state, description, targetURL string) error
+    PostCommitStatus(ctx context.Context, owner, repo, sha, statusContext, state, description, targetURL string) error
+    PostCheckRun(ctx context.Context, owner, repo, sha, state, title, summary string, annotations []checkAnnotation) error
+    ClosePR(ctx context.Context, owner, repo string, prNumber int) error
+    ReopenPR(ctx context.Context, owner, repo string, prNumber int) error
+    PostComment(ctx context.Context, owner, repo string, prNumber int, body string) error
+    PostReviewComment(ctx context.Context, owner, repo string, prNumber int, body string) error
+    // UpsertComment edits the PR comment containing marker, or posts body as a new one
+    UpsertComment(ctx context.Context, owner, repo string, prNumber int, marker, body string) error
+    AddLabels(ctx context.Context, owner, repo string, prNumber int, labels []string) error
+}
+
+// restClient implements GitHubClient over the GitHub REST API
+type restClient struct{}
+
+func (restClient) FetchPRFiles(ctx context.Context, owner, repo string, prNumber int) ([]PRFile, error) {
+    return fetchPRFiles(ctx, owner, repo, prNumber)
+}
+
+func (restClient) FetchPRDetails(ctx context.Context, owner, repo string, prNumber int) (*PRDetails, error) {
+    return fetchPRDetails(ctx, owner, repo, prNumber)
+}
+
+func (restClient) FetchPRDiff(ctx context.Context, owner, repo string, prNumber int) (string, error) {
+    return fetchPRDiff(ctx, owner, repo, prNumber)
+}
+
+func (restClient) FetchPRCommits(ctx context.Context, owner, repo string, prNumber int) ([]Commit, error) {
+    return fetchPRCommits(ctx, owner, repo, prNumber)
+}
+
+func (restClient) FetchPRReviews(ctx context.Context, owner, repo string, prNumber int) ([]Review, error) {
+    return fetchPRReviews(ctx, owner, repo, prNumber)
+}
+
+func (restClient) FetchTree(ctx context.Context, owner, repo, sha string) (map[string]bool, error) {
+    return fetchTree(ctx, owner, repo, sha)
+}
+
+func (restClient) FetchFileContent(ctx context.Context, owner, repo, path, ref string) ([]byte, error) {
+    return fetchFileContent(ctx, owner, repo, path, ref)
+}
+
+func (restClient) CompareCommits(ctx context.Context, owner, repo, base, head string) (*Comparison, error) {
+    return compareCommits(ctx, owner, repo, base, head)
+}
+
+func (restClient) PathHasHistory(ctx context.Context, owner, repo, path, ref string) (bool, error) {
+    return pathHasHistory(ctx, owner, repo, path, ref)
+}
+
+func (restClient) IsOrgMember(ctx context.Context, org, user string) (bool, error) {
+    return isOrgMember(ctx, org, user)
+}
+
+func (restClient) IsTeamMember(ctx context.Context, org, team, user string) (bool, error) {
+    return isTeamMember(ctx, org, team, user)
+}
+
+func (restClient) UpdateStatus(ctx context.Context, owner, repo string, prNumber int, state, description, targetURL string) error {
+    return updatePRStatus(ctx, owner, repo, prNumber, state, description, targetURL)
+}
+
+func (restClient) PostCommitStatus(ctx context.Context, owner, repo, sha, statusContext, state, description, targetURL string) error {
+    return postCommitStatus(ctx, owner, repo, sha, statusContext, state, description, targetURL)
+}
+
+func (restClient) PostCheckRun(ctx context.Context, owner, repo, sha, state, title, summary string, annotations []checkAnnotation) error {
+    return postCheckRun(ctx, owner, repo, sha, state, title, summary, annotations)
+}
+
+func (restClient) ClosePR(ctx context.Context, owner, repo string, prNumber int) error {
+    return closePullRequest(ctx, owner, repo, prNumber)
+}
+
+func (restClient) ReopenPR(ctx context.Context, owner, repo string, prNumber int) error {
+    return reopenPullRequest(ctx, owner, repo, prNumber)
+}
+
+func (restClient) PostComment(ctx context.Context, owner, repo string, prNumber int, body string) error {
+    return postPRComment(ctx, owner, repo, prNumber, body)
+}
+
+func (restClient) PostReviewComment(ctx context.Context, owner, repo string, prNumber int, body string) error {
+    return postPRReviewComment(ctx, owner, repo, prNumber, body)
+}
+
+func (restClient) UpsertComment(ctx context.Context, owner, repo string, prNumber int, marker, body string) error {
+    return upsertPRComment(ctx, owner, repo, prNumber, marker, body)
+}
+
+func (restClient) AddLabels(ctx context.Context, owner, repo string, prNumber int, labels []string) error {
+    return addLabels(ctx, owner, repo, prNumber, labels)
+}
+
+// githubAPI is the client the webhook handler and rules talk to GitHub through
+var githubAPI GitHubClient = restClient{}
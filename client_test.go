@@ -0,0 +1,242 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+)
+
+// fakeGitHub is an in-memory GitHubClient serving one repo's PRs and recording what the
+// handler posts
+type fakeGitHub struct {
+    mu       sync.Mutex
+    files    map[int][]PRFile
+    details  map[int]*PRDetails
+    contents map[string]string // "path@ref" to content
+    statuses []string          // "#pr state: description"
+    // commitStatuses are the "context state" pairs posted, except failContext's, which errors
+    commitStatuses []string
+    failContext    string
+    closed   []int
+    comments []string
+}
+
+func newFakeGitHub() *fakeGitHub {
+    return &fakeGitHub{files: make(map[int][]PRFile), details: make(map[int]*PRDetails), contents: make(map[string]string)}
+}
+
+// useFakeGitHub routes githubAPI to f for the rest of the test
+func useFakeGitHub(t *testing.T, f *fakeGitHub) {
+    saved := githubAPI
+    githubAPI = f
+    t.Cleanup(func() { githubAPI = saved })
+}
+
+func (f *fakeGitHub) FetchPRFiles(ctx context.Context, owner, repo string, prNumber int) ([]PRFile, error) {
+    return f.files[prNumber], nil
+}
+
+func (f *fakeGitHub) FetchPRDetails(ctx context.Context, owner, repo string, prNumber int) (*PRDetails, error) {
+    if d, ok := f.details[prNumber]; ok {
+        return d, nil
+    }
+    return nil, &githubError{StatusCode: 404}
+}
+
+func (f *fakeGitHub) FetchPRDiff(ctx context.Context, owner, repo string, prNumber int) (string, error) { return "", nil }
+
+func (f *fakeGitHub) FetchPRCommits(ctx context.Context, owner, repo string, prNumber int) ([]Commit, error) {
+    return nil, nil
+}
+
+func (f *fakeGitHub) FetchPRReviews(ctx context.Context, owner, repo string, prNumber int) ([]Review, error) {
+    return nil, nil
+}
+
+func (f *fakeGitHub) FetchTree(ctx context.Context, owner, repo, sha string) (map[string]bool, error) {
+    return map[string]bool{}, nil
+}
+
+func (f *fakeGitHub) FetchFileContent(ctx context.Context, owner, repo, path, ref string) ([]byte, error) {
+    if c, ok := f.contents[path+"@"+ref]; ok {
+        return []byte(c), nil
+    }
+    return nil, &githubError{StatusCode: 404}
+}
+
+func (f *fakeGitHub) CompareCommits(ctx context.Context, owner, repo, base, head string) (*Comparison, error) {
+    return &Comparison{}, nil
+}
+
+func (f *fakeGitHub) PathHasHistory(ctx context.Context, owner, repo, path, ref string) (bool, error) { return false, nil }
+
+func (f *fakeGitHub) IsOrgMember(ctx context.Context, org, user string) (bool, error) { return true, nil }
+
+func (f *fakeGitHub) IsTeamMember(ctx context.Context, org, team, user string) (bool, error) { return false, nil }
+
+func (f *fakeGitHub) UpdateStatus(ctx context.Context, owner, repo string, prNumber int, state, description, targetURL string) error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.statuses = append(f.statuses, fmt.Sprintf("#%d %s: %s", prNumber, state, description))
+    return nil
+}
+
+func (f *fakeGitHub) PostCommitStatus(ctx context.Context, owner, repo, sha, statusContext, state, description, targetURL string) error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    if statusContext == f.failContext {
+        return &githubError{StatusCode: 502}
+    }
+    f.commitStatuses = append(f.commitStatuses, statusContext+" "+state)
+    return nil
+}
+
+func (f *fakeGitHub) PostCheckRun(ctx context.Context, owner, repo, sha, state, title, summary string, annotations []checkAnnotation) error {
+    return nil
+}
+
+func (f *fakeGitHub) ClosePR(ctx context.Context, owner, repo string, prNumber int) error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.closed = append(f.closed, prNumber)
+    return nil
+}
+
+func (f *fakeGitHub) ReopenPR(ctx context.Context, owner, repo string, prNumber int) error { return nil }
+
+func (f *fakeGitHub) PostComment(ctx context.Context, owner, repo string, prNumber int, body string) error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.comments = append(f.comments, body)
+    return nil
+}
+
+func (f *fakeGitHub) PostReviewComment(ctx context.Context, owner, repo string, prNumber int, body string) error {
+    return f.PostComment(ctx, owner, repo, prNumber, body)
+}
+
+func (f *fakeGitHub) UpsertComment(ctx context.Context, owner, repo string, prNumber int, marker, body string) error {
+    return f.PostComment(ctx, owner, repo, prNumber, body)
+}
+
+func (f *fakeGitHub) AddLabels(ctx context.Context, owner, repo string, prNumber int, labels []string) error { return nil }
+
+// sendWebhook delivers a pull_request event for o/r#pr to the handler and returns the report
+func sendWebhook(action string, pr int) string {
+    body := fmt.Sprintf(`{"action":%q,"number":%d,"pull_request":{"number":%d,"head":{"sha":"head%d"}},"repository":{"name":"r","owner":{"login":"o"}}}`, action, pr, pr, pr)
+    req := httptest.NewRequest("POST", "/webhook", strings.NewReader(body))
+    req.Header.Set("X-GitHub-Event", "pull_request")
+    rec := httptest.NewRecorder()
+    prWebhookHandler(rec, req)
+    return rec.Body.String()
+}
+
+func TestWebhookHandlerWithFakeGitHub(t *testing.T) {
+    useConfig(t, Config{SchemaVersion: "2"})
+    defer func(a string) { failureAction = a }(failureAction)
+    failureAction = "close"
+    gh := newFakeGitHub()
+    useFakeGitHub(t, gh)
+
+    for _, pr := range []int{1, 2} {
+        d := &PRDetails{Number: pr}
+        d.Head.SHA, d.Base.SHA = fmt.Sprintf("head%d", pr), "base"
+        gh.details[pr] = d
+    }
+    // PR 1 changes a module file; PR 2 changes apps.json without the required schema_version
+    gh.files[1] = []PRFile{{Filename: "billing/api/config.yaml", Status: "modified", Additions: 1, Changes: 1, Patch: "@@ -1 +1 @@\n-a\n+b"}}
+    gh.files[2] = []PRFile{{Filename: "apps.json", Status: "modified", Additions: 1, Changes: 2, Patch: "@@ -1 +1 @@\n-x\n+y"}}
+    gh.contents["apps.json@base"] = `{"schema_version":"2","apps":[{"name":"billing","whitelists":["web1"]}]}`
+    gh.contents["apps.json@head2"] = `{"apps":[{"name":"billing","whitelists":["web1","web2"]}]}`
+
+    if rep := sendWebhook("opened", 1); !strings.Contains(rep, "Status: success") {
+        t.Errorf("clean PR report:\n%s", rep)
+    }
+    if rep := sendWebhook("opened", 2); !strings.Contains(rep, "Status: failure") {
+        t.Errorf("failing PR report:\n%s", rep)
+    }
+
+    if len(gh.statuses) != 2 || !strings.HasPrefix(gh.statuses[0], "#1 success") || !strings.HasPrefix(gh.statuses[1], "#2 failure") {
+        t.Errorf("statuses = %q, want #1 success then #2 failure", gh.statuses)
+    }
+    if len(gh.closed) != 1 || gh.closed[0] != 2 {
+        t.Errorf("closed PRs = %v, want [2]", gh.closed)
+    }
+}
+
+func TestRollupStatus(t *testing.T) {
+    gh := newFakeGitHub()
+    gh.failContext = "commitvalidator/same-repo"
+    useFakeGitHub(t, gh)
+
+    results := []ruleResult{
+        {Rule: "same-repo", Failures: []string{"fork"}},
+        {Rule: "signed-commits", Skipped: true, SkipReason: "not configured"},
+        {Rule: "app-approvals", Pending: []string{"needs 1 more approval"}},
+        {Rule: "utf8"},
+    }
+    if err := postRollupStatus(context.Background(), "o", "r", "sha", "success", results); err == nil {
+        t.Error("want the same-repo post error returned")
+    }
+    want := []string{"commitvalidator/app-approvals pending", "commitvalidator/utf8 success", "commitvalidator/all failure"}
+    if fmt.Sprint(gh.commitStatuses) != fmt.Sprint(want) {
+        t.Errorf("statuses = %q, want %q", gh.commitStatuses, want)
+    }
+
+    gh.failContext, gh.commitStatuses = "", nil
+    if err := postRollupStatus(context.Background(), "o", "r", "sha", "success", results[1:]); err != nil {
+        t.Fatal(err)
+    }
+    if got := gh.commitStatuses[len(gh.commitStatuses)-1]; got != "commitvalidator/all pending" {
+        t.Errorf("rollup = %q, want pending when nothing failed but a rule is pending", got)
+    }
+}
+
+func TestAdminReopenOnlySparesTheReopenedValidation(t *testing.T) {
+    useConfig(t, Config{SchemaVersion: "2"})
+    defer func(a string) { failureAction = a }(failureAction)
+    failureAction = "close"
+    gh := newFakeGitHub()
+    useFakeGitHub(t, gh)
+
+    d := &PRDetails{Number: 3}
+    d.Head.SHA, d.Base.SHA = "head3", "base"
+    gh.details[3] = d
+    gh.files[3] = []PRFile{{Filename: "apps.json", Status: "modified", Additions: 1, Changes: 2, Patch: "@@ -1 +1 @@\n-x\n+y"}}
+    gh.contents["apps.json@base"] = `{"schema_version":"2","apps":[]}`
+    gh.contents["apps.json@head3"] = `{"apps":[]}`
+
+    setAdminReopened("o", "r", 3, true)
+    if sendWebhook("reopened", 3); len(gh.closed) != 0 {
+        t.Fatalf("closed PRs = %v after the admin reopen, want none", gh.closed)
+    }
+    if sendWebhook("synchronize", 3); len(gh.closed) != 1 || gh.closed[0] != 3 {
+        t.Errorf("closed PRs = %v after a failing push, want [3]", gh.closed)
+    }
+    if isMarked := takeAdminReopened("o", "r", 3); isMarked {
+        t.Error("PR still marked as admin-reopened")
+    }
+}
+
+func TestListConflictsFailWithoutConfiguration(t *testing.T) {
+    useConfig(t, Config{})
+    gh := newFakeGitHub()
+    useFakeGitHub(t, gh)
+
+    d := &PRDetails{Number: 4}
+    d.Head.SHA, d.Base.SHA = "head4", "base"
+    gh.details[4] = d
+    gh.files[4] = []PRFile{{Filename: "apps.json", Status: "modified", Additions: 1, Changes: 2, Patch: "@@ -1 +1 @@\n-x\n+y"}}
+    gh.contents["apps.json@base"] = `{"apps":[{"name":"billing","whitelists":["web1"]}]}`
+    gh.contents["apps.json@head4"] = `{"apps":[{"name":"billing","whitelists":["web1","web2"],"blacklists":["web2"]}]}`
+
+    if rep := sendWebhook("opened", 4); !strings.Contains(rep, "Status: failure") {
+        t.Errorf("conflicting lists report:\n%s", rep)
+    }
+    if len(gh.statuses) != 1 || !strings.Contains(gh.statuses[0], "web2 in both whitelists and blacklists") {
+        t.Errorf("statuses = %q, want a failure naming web2", gh.statuses)
+    }
+}
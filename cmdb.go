@@ -0,0 +1,95 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "sort"
+    "strings"
+    "time"
+)
+
+// CMDBResolver expands a cmdb_whitelists/cmdb_blacklists entry into concrete servers
+type CMDBResolver interface {
+    ResolveGroup(ctx context.Context, key, value string) ([]string, error)
+}
+
+// errUnknownCMDBGroup is returned by a CMDBResolver when the CMDB has no such group, as opposed
+// to the CMDB being unavailable
+var errUnknownCMDBGroup = errors.New("no such CMDB group")
+
+// cmdbResolver resolves CMDB entries; nil means entries are treated as literal server names
+var cmdbResolver CMDBResolver
+
+// httpCMDBResolver queries a CMDB API at GET {baseURL}/servers?{key}={value},
+// which responds with {"servers": ["host1", ...]}, or 404 for an unknown group
+type httpCMDBResolver struct {
+    baseURL string
+}
+
+// ResolveGroup returns the servers the CMDB lists for key=value
+func (c httpCMDBResolver) ResolveGroup(ctx context.Context, key, value string) ([]string, error) {
+    u := strings.TrimRight(c.baseURL, "/") + "/servers?" + url.Values{key: {value}}.Encode()
+    req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+    if err != nil {
+        return nil, err
+    }
+    client := &http.Client{Timeout: 30 * time.Second}
+    resp, err := client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode == http.StatusNotFound {
+        return nil, errUnknownCMDBGroup
+    }
+    if resp.StatusCode != 200 {
+        body, _ := ioutil.ReadAll(resp.Body)
+        return nil, fmt.Errorf("CMDB API error (%d): %s", resp.StatusCode, string(body))
+    }
+    var result struct {
+        Servers []string `json:"servers"`
+    }
+    decoder := json.NewDecoder(resp.Body)
+    if err := decoder.Decode(&result); err != nil {
+        return nil, err
+    }
+    return result.Servers, nil
+}
+
+// cmdbEntryString renders a CMDB entry like {"group":"payments-prod"} as group=payments-prod
+func cmdbEntryString(m map[string]string) string {
+    var parts []string
+    for k, v := range m {
+        parts = append(parts, k+"="+v)
+    }
+    sort.Strings(parts)
+    return strings.Join(parts, ",")
+}
+
+// resolveCMDBEntry returns the servers a CMDB entry refers to. Without a resolver
+// the entry's values are the server names.
+func resolveCMDBEntry(ctx context.Context, m map[string]string) ([]string, error) {
+    var keys []string
+    for k := range m {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    var servers []string
+    for _, k := range keys {
+        if cmdbResolver == nil {
+            servers = append(servers, m[k])
+            continue
+        }
+        resolved, err := cmdbResolver.ResolveGroup(ctx, k, m[k])
+        if err != nil {
+            return nil, fmt.Errorf("resolving CMDB entry %s=%s: %w", k, m[k], err)
+        }
+        servers = append(servers, resolved...)
+    }
+    return servers, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+// fakeCMDB resolves groups from a map and reports the rest as unknown
+type fakeCMDB map[string][]string
+
+func (f fakeCMDB) ResolveGroup(ctx context.Context, key, value string) ([]string, error) {
+    servers, ok := f[key+"="+value]
+    if !ok {
+        return nil, errUnknownCMDBGroup
+    }
+    return servers, nil
+}
+
+// useCMDB sets cmdbResolver for the rest of the test
+func useCMDB(t *testing.T, r CMDBResolver) {
+    saved := cmdbResolver
+    cmdbResolver = r
+    t.Cleanup(func() { cmdbResolver = saved })
+}
+
+func TestResolveCMDBEntry(t *testing.T) {
+    useCMDB(t, fakeCMDB{"group=payments": {"pay1", "pay2"}})
+
+    servers, err := resolveCMDBEntry(context.Background(), map[string]string{"group": "payments"})
+    if err != nil || strings.Join(servers, ",") != "pay1,pay2" {
+        t.Errorf("got %v, %v; want pay1 and pay2", servers, err)
+    }
+    if _, err := resolveCMDBEntry(context.Background(), map[string]string{"group": "nope"}); !errors.Is(err, errUnknownCMDBGroup) {
+        t.Errorf("unknown group: got %v, want errUnknownCMDBGroup", err)
+    }
+}
+
+func TestUnknownCMDBGroupFailsPR(t *testing.T) {
+    useConfig(t, Config{CMDBFailureAction: "warn"})
+    useCMDB(t, fakeCMDB{"group=payments": {"pay1"}})
+    useFakeGitHub(t, newFakeGitHub())
+    pc := &prContext{
+        Owner: "o", Repo: "r", Number: 1,
+        Files:           []PRFile{{Filename: "apps.json", Status: "modified", Patch: "@@ -1 +1 @@"}},
+        AppServers:      make(map[string]map[string]bool),
+        BaseAppServers:  make(map[string]map[string]bool),
+        ImpactedServers: make(map[string]bool),
+        ProdServers:     make(map[string]bool),
+        fetchFile: func(ctx context.Context, path, ref string) ([]byte, error) {
+            if ref == "main" {
+                return []byte(`{"apps":[]}`), nil
+            }
+            return []byte(`{"apps":[{"name":"billing","cmdb_whitelists":[{"group":"payments"}]},{"name":"ledger","cmdb_whitelists":[{"group":"typo"}]}]}`), nil
+        },
+    }
+    var rep bytes.Buffer
+    v := validateChanges(context.Background(), pc, &rep, log.New(ioutil.Discard, "", 0))
+
+    if v.Status != "failure" || len(v.Violations) != 1 || !strings.Contains(v.Violations[0], "app ledger references a CMDB group") {
+        t.Errorf("got status %s, violations %q; want one failure for ledger", v.Status, v.Violations)
+    }
+    if !pc.ImpactedServers["pay1"] {
+        t.Errorf("impacted servers = %v, want pay1 from the known group", pc.ImpactedServers)
+    }
+}
+
+func TestHTTPCMDBResolver(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != "/servers" || r.URL.Query().Get("group") != "payments" {
+            http.NotFound(w, r)
+            return
+        }
+        fmt.Fprint(w, `{"servers":["pay1","pay2"]}`)
+    }))
+    defer srv.Close()
+    c := httpCMDBResolver{baseURL: srv.URL + "/"}
+
+    if servers, err := c.ResolveGroup(context.Background(), "group", "payments"); err != nil || len(servers) != 2 {
+        t.Errorf("got %v, %v; want two servers", servers, err)
+    }
+    if _, err := c.ResolveGroup(context.Background(), "group", "nope"); !errors.Is(err, errUnknownCMDBGroup) {
+        t.Errorf("404: got %v, want errUnknownCMDBGroup", err)
+    }
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "net/http"
+    "os"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Duration is a time.Duration that unmarshals from strings like "10m"
+type Duration struct {
+    time.Duration
+}
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+    var s string
+    if err := json.Unmarshal(b, &s); err != nil {
+        return err
+    }
+    v, err := time.ParseDuration(s)
+    if err != nil {
+        return err
+    }
+    d.Duration = v
+    return nil
+}
+
+// MarshalJSON renders the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+    return json.Marshal(d.String())
+}
+
+// Config holds the validation policy read from the JSON file at CONFIG_PATH
+type Config struct {
+    // SchemaVersion is the schema_version apps.json must declare (empty disables the check)
+    SchemaVersion string `json:"schema_version"`
+    // CloseCooldown is how long after closing a PR a reopen won't auto-close it again
+    CloseCooldown Duration `json:"close_cooldown"`
+    // ReopenWindow is how far back /admin/reopen looks for PRs the validator closed (defaults to 24h)
+    ReopenWindow Duration `json:"reopen_window"`
+    // AppsFiles lists the app-config files whose app names must be unique across all of them
+    AppsFiles []string `json:"apps_files"`
+    // ServerEnvRegex extracts a server's environment, from the "env" group or else the first group
+    ServerEnvRegex string `json:"server_env_regex"`
+    // ProdEnvs are the extracted environments treated as prod (defaults to "prod")
+    ProdEnvs []string `json:"prod_envs"`
+    // MaxProdImpact caps how many prod servers one PR may impact (0 disables the check)
+    MaxProdImpact int `json:"max_prod_impact"`
+    // ProdImpactOverrideLabel lets a PR exceed MaxProdImpact when it carries this label
+    ProdImpactOverrideLabel string `json:"prod_impact_override_label"`
+    // RequireSameRepo is "warn" or "fail" to flag PRs whose head repo owner differs from the base
+    RequireSameRepo string `json:"require_same_repo"`
+    // RuleTimeout bounds how long any one rule may run (defaults to 30s)
+    RuleTimeout Duration `json:"rule_timeout"`
+    // RuleTimeouts overrides RuleTimeout for individual rules by name
+    RuleTimeouts map[string]Duration `json:"rule_timeouts"`
+    // RollupStatus posts a status per rule plus an aggregate commitvalidator/all status
+    RollupStatus bool `json:"rollup_status"`
+    // SelfConfigPaths are paths or globs of the validator's own config kept in the repo
+    SelfConfigPaths []string `json:"self_config_paths"`
+    // SelfConfigApprovers are logins or "org/team" slugs whose approval allows SelfConfigPaths changes
+    SelfConfigApprovers []string `json:"self_config_approvers"`
+    // AppRequiredFiles maps app names (or "*" for every app) to files new apps must contain
+    AppRequiredFiles map[string][]string `json:"app_required_files"`
+    // RequireSignedCommits fails PRs with commits lacking a verified GPG/SSH signature
+    RequireSignedCommits bool `json:"require_signed_commits"`
+    // AlwaysCloseAuthors are PR authors (typically bots) whose failing PRs are always closed
+    AlwaysCloseAuthors []string `json:"always_close_authors"`
+    // UTF8Extensions are file extensions (like ".yaml") whose content must be valid UTF-8
+    UTF8Extensions []string `json:"utf8_extensions"`
+    // RequireLinearHistory fails PRs that contain merge commits
+    RequireLinearHistory bool `json:"require_linear_history"`
+    // MaxAddedFilesByExtension caps how many files with each lowercase extension (like ".sql") a PR may add
+    MaxAddedFilesByExtension map[string]int `json:"max_added_files_by_extension"`
+    // EmptyPRAction is "neutral" (the default) or "fail" for PRs with no changed files
+    EmptyPRAction string `json:"empty_pr_action"`
+    // CriticalFiles are paths or globs (like ".github/CODEOWNERS") that PRs may not delete or rename
+    CriticalFiles []string `json:"critical_files"`
+    // CheckStaleBlacklists warns about blacklist entries that no app in apps.json whitelists
+    CheckStaleBlacklists bool `json:"check_stale_blacklists"`
+    // MaxCommits caps the number of commits in a PR (0 disables the check)
+    MaxCommits int `json:"max_commits"`
+    // MaxCommitsAction is "fail" (the default) or "warn" when MaxCommits is exceeded
+    MaxCommitsAction string `json:"max_commits_action"`
+    // FailureLabels maps rule names to labels applied to the PR when that rule fails
+    FailureLabels map[string][]string `json:"failure_labels"`
+    // CheckFileModes is "warn" or "fail" to flag files a PR makes executable
+    CheckFileModes string `json:"check_file_modes"`
+    // AppServerPatterns maps apps to regexes every server they whitelist must match one of
+    AppServerPatterns map[string][]string `json:"app_server_patterns"`
+    // AppRequiredApprovals maps apps to the approvals PRs touching them need before passing
+    AppRequiredApprovals map[string]int `json:"app_required_approvals"`
+    // RequireRegisteredApps fails changes under app directories that apps.json doesn't list
+    RequireRegisteredApps bool `json:"require_registered_apps"`
+    // RollbackSection is a PR body heading (like "## Rollback") required when a PR impacts prod servers
+    RollbackSection string `json:"rollback_section"`
+    // MandatoryStatusPaths are paths or globs that, when changed, get their own commitvalidator/mandatory-paths
+    // status so branch protection can require it for sensitive directories
+    MandatoryStatusPaths []string `json:"mandatory_status_paths"`
+    // ImpactDeltaComments comments on each push with the servers it added to or removed from the PR's impact
+    ImpactDeltaComments bool `json:"impact_delta_comments"`
+    // CMDBTicketPattern is a regex a PR's description must match when it changes any cmdb_whitelists or cmdb_blacklists
+    CMDBTicketPattern string `json:"cmdb_ticket_pattern"`
+    // WhitespaceOnlyAction is "warn" or "fail" to flag PRs whose changes are all whitespace
+    WhitespaceOnlyAction string `json:"whitespace_only_action"`
+    // MaxAppServers caps how many servers one app may whitelist, cmdb_whitelists included (0 disables the check)
+    MaxAppServers int `json:"max_app_servers"`
+    // ForbiddenFiles are paths or globs, matched against full paths and base names, that PRs may not add or
+    // change (default forbidden.txt; [] disables the check)
+    ForbiddenFiles []string `json:"forbidden_files"`
+    // NonMemberAction is "skip" to ignore PRs from authors outside the repo's org, or "neutral" or "fail"
+    // to report them
+    NonMemberAction string `json:"non_member_action"`
+    // MaxHeadAge flags PRs whose head commit is older than this (0 disables the check)
+    MaxHeadAge Duration `json:"max_head_age"`
+    // MaxBehindBy flags PRs whose head is more than this many commits behind the base branch (0 disables the check)
+    MaxBehindBy int `json:"max_behind_by"`
+    // StaleHeadAction is "fail" (the default) or "warn" when MaxHeadAge or MaxBehindBy is exceeded
+    StaleHeadAction string `json:"stale_head_action"`
+    // MaintenanceLookahead is how far ahead a maintenance window may start and still cover an
+    // impacted prod server (defaults to 7 days)
+    MaintenanceLookahead Duration `json:"maintenance_lookahead"`
+    // LockfileManifests maps lockfile names (like "go.sum") to the manifest (like "go.mod") that must
+    // change in the same directory whenever the lockfile does
+    LockfileManifests map[string]string `json:"lockfile_manifests"`
+    // ReportURL is linked from statuses, with {report_id} replaced by the run's report ID
+    // (like a log search URL)
+    ReportURL string `json:"report_url"`
+    // OmittedPatchAction is "note" (the default) to warn about changed files GitHub sent no patch for,
+    // or "diff" to recover their patches from the PR's full diff first
+    OmittedPatchAction string `json:"omitted_patch_action"`
+    // ImpactApprovalThreshold is the impacted-server count above which a PR needs
+    // ImpactRequiredApprovals approvals (0 disables the check)
+    ImpactApprovalThreshold int `json:"impact_approval_threshold"`
+    // ImpactRequiredApprovals is how many approvals high-impact PRs need (defaults to 2)
+    ImpactRequiredApprovals int `json:"impact_required_approvals"`
+    // AppsJsonHygiene is "whitespace" to fail app names and servers with surrounding whitespace in
+    // modified apps, or "lower" or "upper" to also require that casing
+    AppsJsonHygiene string `json:"apps_json_hygiene"`
+    // CMDBFailureAction is what happens when CMDB entries can't be resolved: "warn" (the default) to
+    // note it and use static whitelists only, "fail" to block the PR, or "skip" to leave the app out
+    CMDBFailureAction string `json:"cmdb_failure_action"`
+    // MaxModulesPerApp is how many modules of one app a PR may change without MultiModuleAck (0 disables the check)
+    MaxModulesPerApp int `json:"max_modules_per_app"`
+    // MultiModuleAck is a PR label, or text in the PR description, acknowledging a change spanning many modules
+    MultiModuleAck string `json:"multi_module_ack"`
+    // DNSCheckAction is "warn" or "fail" to flag servers newly added to whitelists that don't resolve in DNS
+    DNSCheckAction string `json:"dns_check_action"`
+    // DNSResolver is a "host:port" DNS server to use for DNSCheckAction instead of the system resolver
+    DNSResolver string `json:"dns_resolver"`
+    // DNSTimeout bounds each DNS lookup (defaults to 2s)
+    DNSTimeout Duration `json:"dns_timeout"`
+    // AppTrackingIssues maps apps to issue numbers in the same repo that get a comment per PR
+    // touching the app, with its impacted-server delta
+    AppTrackingIssues map[string]int `json:"app_tracking_issues"`
+    // AppForbiddenBases maps apps to base branch names or globs (like "release/*") their files may not be changed on
+    AppForbiddenBases map[string][]string `json:"app_forbidden_bases"`
+    // CheckOverlappingPRs warns when a PR impacts servers another open PR also impacts
+    CheckOverlappingPRs bool `json:"check_overlapping_prs"`
+    // ReopenAction is "revalidate-immediately" (the default) or "require-new-commit" to leave reopened
+    // PRs that last failed alone, with a comment, until new commits are pushed
+    ReopenAction string `json:"reopen_action"`
+    // CheckRemovedServers warns when a server removed from an app's whitelists is still referenced by
+    // another app in apps.json or the apps_files
+    CheckRemovedServers bool `json:"check_removed_servers"`
+    // StatusFallbackComment posts the result as a PR comment when the status or check run can't be posted
+    StatusFallbackComment bool `json:"status_fallback_comment"`
+    // Rules holds per-rule settings keyed by rule name
+    Rules map[string]RuleSettings `json:"rules"`
+    // Profiles are named sets of rules
+    Profiles map[string][]string `json:"profiles"`
+    // DefaultProfile is the profile for PRs without a profile label (empty runs every rule)
+    DefaultProfile string `json:"default_profile"`
+    // LabelProfiles maps PR labels to the profile to use instead of the default
+    LabelProfiles map[string]string `json:"label_profiles"`
+
+    serverEnvRe   *regexp.Regexp
+    appServerRes  map[string][]*regexp.Regexp
+    cmdbTicketRe  *regexp.Regexp
+}
+
+// RuleSettings configures a single rule
+type RuleSettings struct {
+    // Enabled turns the rule off when false; rules are enabled by default
+    Enabled *bool `json:"enabled,omitempty"`
+}
+
+// config is the policy the webhook handler validates against
+var config Config
+
+// loadConfig reads the policy file at path. A missing file yields the default Config.
+func loadConfig(path string) (Config, error) {
+    var c Config
+    data, err := ioutil.ReadFile(path)
+    if err != nil && !os.IsNotExist(err) {
+        return c, err
+    }
+    if err == nil {
+        if err := json.Unmarshal(data, &c); err != nil {
+            return c, err
+        }
+    }
+    if c.ServerEnvRegex != "" {
+        if c.serverEnvRe, err = regexp.Compile(c.ServerEnvRegex); err != nil {
+            return c, err
+        }
+    }
+    if c.CMDBTicketPattern != "" {
+        if c.cmdbTicketRe, err = regexp.Compile(c.CMDBTicketPattern); err != nil {
+            return c, fmt.Errorf("cmdb_ticket_pattern: %v", err)
+        }
+    }
+    for app, patterns := range c.AppServerPatterns {
+        for _, p := range patterns {
+            re, err := regexp.Compile(p)
+            if err != nil {
+                return c, fmt.Errorf("app_server_patterns for %s: %v", app, err)
+            }
+            if c.appServerRes == nil {
+                c.appServerRes = make(map[string][]*regexp.Regexp)
+            }
+            c.appServerRes[app] = append(c.appServerRes[app], re)
+        }
+    }
+    if c.ForbiddenFiles == nil {
+        c.ForbiddenFiles = []string{"forbidden.txt"}
+    }
+    // Extensions are compared lowercased, so ".SQL" and ".sql" configure the same limit
+    if len(c.MaxAddedFilesByExtension) > 0 {
+        limits := make(map[string]int, len(c.MaxAddedFilesByExtension))
+        for ext, limit := range c.MaxAddedFilesByExtension {
+            ext = strings.ToLower(ext)
+            if prev, ok := limits[ext]; ok && prev != limit {
+                return c, fmt.Errorf("max_added_files_by_extension sets %s more than once with different limits", ext)
+            }
+            limits[ext] = limit
+        }
+        c.MaxAddedFilesByExtension = limits
+    }
+    switch c.EmptyPRAction {
+    case "", "neutral", "fail":
+    default:
+        return c, fmt.Errorf("empty_pr_action must be neutral or fail, got %q", c.EmptyPRAction)
+    }
+    switch c.ReopenAction {
+    case "":
+        c.ReopenAction = "revalidate-immediately"
+    case "revalidate-immediately", "require-new-commit":
+    default:
+        return c, fmt.Errorf("reopen_action must be revalidate-immediately or require-new-commit, got %q", c.ReopenAction)
+    }
+    switch c.CMDBFailureAction {
+    case "":
+        c.CMDBFailureAction = "warn"
+    case "warn", "fail", "skip":
+    default:
+        return c, fmt.Errorf("cmdb_failure_action must be warn, fail or skip, got %q", c.CMDBFailureAction)
+    }
+    switch c.AppsJsonHygiene {
+    case "", "whitespace", "lower", "upper":
+    default:
+        return c, fmt.Errorf("apps_json_hygiene must be whitespace, lower or upper, got %q", c.AppsJsonHygiene)
+    }
+    switch c.OmittedPatchAction {
+    case "", "note", "diff":
+    default:
+        return c, fmt.Errorf("omitted_patch_action must be note or diff, got %q", c.OmittedPatchAction)
+    }
+    switch c.NonMemberAction {
+    case "", "skip", "neutral", "fail":
+    default:
+        return c, fmt.Errorf("non_member_action must be skip, neutral or fail, got %q", c.NonMemberAction)
+    }
+    switch c.MaxCommitsAction {
+    case "", "fail", "warn":
+    default:
+        return c, fmt.Errorf("max_commits_action must be fail or warn, got %q", c.MaxCommitsAction)
+    }
+    switch c.DNSCheckAction {
+    case "", "warn", "fail":
+    default:
+        return c, fmt.Errorf("dns_check_action must be warn or fail, got %q", c.DNSCheckAction)
+    }
+    switch c.StaleHeadAction {
+    case "", "fail", "warn":
+    default:
+        return c, fmt.Errorf("stale_head_action must be fail or warn, got %q", c.StaleHeadAction)
+    }
+    switch c.WhitespaceOnlyAction {
+    case "", "warn", "fail":
+    default:
+        return c, fmt.Errorf("whitespace_only_action must be warn or fail, got %q", c.WhitespaceOnlyAction)
+    }
+    switch c.CheckFileModes {
+    case "", "warn", "fail":
+    default:
+        return c, fmt.Errorf("check_file_modes must be warn or fail, got %q", c.CheckFileModes)
+    }
+    switch c.RequireSameRepo {
+    case "", "warn", "fail":
+    default:
+        return c, fmt.Errorf("require_same_repo must be warn or fail, got %q", c.RequireSameRepo)
+    }
+    for name := range c.FailureLabels {
+        if _, ok := ruleByName(name); !ok {
+            return c, fmt.Errorf("failure_labels configures unknown rule %q", name)
+        }
+    }
+    for name := range c.Rules {
+        if _, ok := ruleByName(name); !ok {
+            return c, fmt.Errorf("rules configures unknown rule %q", name)
+        }
+    }
+    for name, ruleNames := range c.Profiles {
+        for _, r := range ruleNames {
+            if _, ok := ruleByName(r); !ok {
+                return c, fmt.Errorf("profile %q references unknown rule %q", name, r)
+            }
+        }
+    }
+    for label, p := range c.LabelProfiles {
+        if _, ok := c.Profiles[p]; !ok {
+            return c, fmt.Errorf("label %q maps to unknown profile %q", label, p)
+        }
+    }
+    if c.DefaultProfile != "" {
+        if _, ok := c.Profiles[c.DefaultProfile]; !ok {
+            return c, fmt.Errorf("default_profile %q is not defined", c.DefaultProfile)
+        }
+    }
+    if len(c.ProdEnvs) == 0 {
+        c.ProdEnvs = []string{"prod"}
+    }
+    if c.ReopenWindow.Duration <= 0 {
+        c.ReopenWindow.Duration = 24 * time.Hour
+    }
+    if c.DNSTimeout.Duration <= 0 {
+        c.DNSTimeout.Duration = 2 * time.Second
+    }
+    if c.ImpactRequiredApprovals <= 0 {
+        c.ImpactRequiredApprovals = 2
+    }
+    if c.MaintenanceLookahead.Duration <= 0 {
+        c.MaintenanceLookahead.Duration = 7 * 24 * time.Hour
+    }
+    if c.RuleTimeout.Duration <= 0 {
+        c.RuleTimeout.Duration = 30 * time.Second
+    }
+    return c, nil
+}
+
+// envInt reads an integer environment variable, falling back to def when unset or invalid
+func envInt(key string, def int) int {
+    v := os.Getenv(key)
+    if v == "" {
+        return def
+    }
+    n, err := strconv.Atoi(v)
+    if err != nil {
+        log.Printf("Invalid %s %q, using default %d", key, v, def)
+        return def
+    }
+    return n
+}
+
+// envDuration reads a duration environment variable like "30s" (a bare number is seconds),
+// falling back to def when unset, invalid or not positive
+func envDuration(key string, def time.Duration) time.Duration {
+    v := os.Getenv(key)
+    if v == "" {
+        return def
+    }
+    d, err := time.ParseDuration(v)
+    if n, nerr := strconv.Atoi(v); nerr == nil {
+        d, err = time.Duration(n)*time.Second, nil
+    }
+    if err != nil || d <= 0 {
+        log.Printf("Invalid %s %q, using default %s", key, v, def)
+        return def
+    }
+    return d
+}
+
+// envBool reads a boolean environment variable, treating unset or invalid values as false
+func envBool(key string) bool {
+    v, err := strconv.ParseBool(os.Getenv(key))
+    return err == nil && v
+}
+
+// configHandler serves the active config along with which rules are enabled
+func configHandler(w http.ResponseWriter, r *http.Request) {
+    type ruleState struct {
+        Name    string `json:"name"`
+        Enabled bool   `json:"enabled"`
+    }
+    var states []ruleState
+    for _, rule := range rules {
+        states = append(states, ruleState{Name: rule.Name, Enabled: ruleEnabled(rule.Name)})
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(struct {
+        Config Config      `json:"config"`
+        Rules  []ruleState `json:"rules"`
+    }{config, states})
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestEnvDurationRejectsNonPositiveValues(t *testing.T) {
+    tests := []struct {
+        value string
+        want  time.Duration
+    }{
+        {"", time.Minute},
+        {"45", 45 * time.Second},
+        {"250ms", 250 * time.Millisecond},
+        {"0", time.Minute},
+        {"0s", time.Minute},
+        {"-5", time.Minute},
+        {"-1s", time.Minute},
+        {"soon", time.Minute},
+    }
+    for _, tt := range tests {
+        t.Setenv("TEST_DURATION", tt.value)
+        if got := envDuration("TEST_DURATION", time.Minute); got != tt.want {
+            t.Errorf("envDuration(%q) = %s, want %s", tt.value, got, tt.want)
+        }
+    }
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "log"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "time"
+)
+
+// unsafeFilenameChars matches characters not allowed in debug payload filenames
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// saveDebugPayload writes a raw webhook payload to DEBUG_PAYLOAD_DIR when set.
+// Payloads are truncated to DEBUG_PAYLOAD_MAX_BYTES and only the newest
+// DEBUG_PAYLOAD_MAX_FILES files are kept.
+func saveDebugPayload(deliveryID string, payload []byte) {
+    dir := os.Getenv("DEBUG_PAYLOAD_DIR")
+    if dir == "" {
+        return
+    }
+    maxBytes := envInt("DEBUG_PAYLOAD_MAX_BYTES", 1<<20)
+    maxFiles := envInt("DEBUG_PAYLOAD_MAX_FILES", 100)
+
+    if deliveryID == "" {
+        deliveryID = "unknown"
+    }
+    if len(payload) > maxBytes {
+        log.Printf("Debug payload for delivery %s is %d bytes, truncating to %d", deliveryID, len(payload), maxBytes)
+        payload = payload[:maxBytes]
+    }
+    if err := os.MkdirAll(dir, 0o700); err != nil {
+        log.Printf("Could not create debug payload dir %s: %v", dir, err)
+        return
+    }
+    name := fmt.Sprintf("%s-%s.json", time.Now().UTC().Format("20060102T150405.000Z"), unsafeFilenameChars.ReplaceAllString(deliveryID, "_"))
+    if err := ioutil.WriteFile(filepath.Join(dir, name), payload, 0o600); err != nil {
+        log.Printf("Could not write debug payload %s: %v", name, err)
+        return
+    }
+    pruneDebugPayloads(dir, maxFiles)
+}
+
+// pruneDebugPayloads removes the oldest payload files so at most maxFiles remain
+func pruneDebugPayloads(dir string, maxFiles int) {
+    matches, err := filepath.Glob(filepath.Join(dir, "*.json"))
+    if err != nil || len(matches) <= maxFiles {
+        return
+    }
+    // Filenames start with a UTC timestamp, so lexical order is chronological
+    sort.Strings(matches)
+    for _, path := range matches[:len(matches)-maxFiles] {
+        if err := os.Remove(path); err != nil {
+            log.Printf("Could not remove old debug payload %s: %v", path, err)
+        }
+    }
+}
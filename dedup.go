@@ -0,0 +1,72 @@
+package main
+
+import (
+    "container/list"
+    "sync"
+    "time"
+)
+
+// dedupStore remembers recent webhook delivery IDs so redeliveries aren't processed twice.
+// Entries expire after ttl and the least recently seen are evicted beyond maxEntries.
+type dedupStore struct {
+    mu         sync.Mutex
+    ttl        time.Duration
+    maxEntries int
+    order      *list.List // of *dedupEntry, most recently seen first
+    byID       map[string]*list.Element
+}
+
+type dedupEntry struct {
+    id   string
+    seen time.Time
+}
+
+// newDedupStore creates an empty store
+func newDedupStore(ttl time.Duration, maxEntries int) *dedupStore {
+    return &dedupStore{ttl: ttl, maxEntries: maxEntries, order: list.New(), byID: make(map[string]*list.Element)}
+}
+
+// Seen records id and reports whether it was already recorded within the TTL
+func (d *dedupStore) Seen(id string) bool {
+    now := time.Now()
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    // Expired entries collect at the back
+    for e := d.order.Back(); e != nil && now.Sub(e.Value.(*dedupEntry).seen) > d.ttl; e = d.order.Back() {
+        d.remove(e)
+    }
+    if e, ok := d.byID[id]; ok {
+        e.Value.(*dedupEntry).seen = now
+        d.order.MoveToFront(e)
+        return true
+    }
+    d.byID[id] = d.order.PushFront(&dedupEntry{id: id, seen: now})
+    for d.order.Len() > d.maxEntries {
+        d.remove(d.order.Back())
+    }
+    return false
+}
+
+// Forget drops id, so a redelivery of it is processed again
+func (d *dedupStore) Forget(id string) {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    if e, ok := d.byID[id]; ok {
+        d.remove(e)
+    }
+}
+
+// Len is the number of delivery IDs remembered
+func (d *dedupStore) Len() int {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    return d.order.Len()
+}
+
+func (d *dedupStore) remove(e *list.Element) {
+    d.order.Remove(e)
+    delete(d.byID, e.Value.(*dedupEntry).id)
+}
+
+// deliveries dedups webhook deliveries by X-GitHub-Delivery (DEDUP_TTL, DEDUP_MAX_ENTRIES)
+var deliveries = newDedupStore(time.Hour, 10000)
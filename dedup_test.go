@@ -0,0 +1,47 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestFailedDeliveryIsProcessedOnRedelivery(t *testing.T) {
+    useConfig(t, Config{})
+    filesRequests := 0
+    mockGitHub(t, func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/repos/o/r/pulls/1/files" {
+            filesRequests++
+        }
+        http.Error(w, `{"message":"Server Error"}`, http.StatusBadGateway)
+    })
+
+    deliver := func() string {
+        req := httptest.NewRequest("POST", "/webhook", strings.NewReader(`{"action":"opened","number":1,"repository":{"name":"r","owner":{"login":"o"}}}`))
+        req.Header.Set("X-GitHub-Event", "pull_request")
+        req.Header.Set("X-GitHub-Delivery", "failed-delivery")
+        rec := httptest.NewRecorder()
+        prWebhookHandler(rec, req)
+        return rec.Body.String()
+    }
+    deliver()
+    if body := deliver(); strings.Contains(body, "Duplicate delivery") {
+        t.Fatalf("redelivery of a failed delivery was dropped: %s", body)
+    }
+    if filesRequests != 2 {
+        t.Errorf("files fetched %d times, want once per delivery", filesRequests)
+    }
+}
+
+func TestDedupStoreForget(t *testing.T) {
+    d := newDedupStore(time.Hour, 10)
+    if d.Seen("a") || !d.Seen("a") {
+        t.Fatal("second Seen of a should report it as seen")
+    }
+    d.Forget("a")
+    if d.Seen("a") {
+        t.Error("a still seen after Forget")
+    }
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+    "strings"
+    "unicode"
+)
+
+// modeChange is a file whose mode a diff changes
+type modeChange struct {
+    Filename string
+    OldMode  string
+    NewMode  string
+}
+
+// parseModeChanges extracts the "old mode"/"new mode" pairs from a git diff, and the
+// "new file mode" of added files
+func parseModeChanges(diff string) []modeChange {
+    var changes []modeChange
+    var current modeChange
+    for _, line := range strings.Split(diff, "\n") {
+        switch {
+        case strings.HasPrefix(line, "diff --git "):
+            // diff --git a/path b/path
+            current = modeChange{}
+            if i := strings.Index(line, " b/"); i >= 0 {
+                current.Filename = line[i+len(" b/"):]
+            }
+        case strings.HasPrefix(line, "old mode "):
+            current.OldMode = strings.TrimPrefix(line, "old mode ")
+        case strings.HasPrefix(line, "new mode "):
+            current.NewMode = strings.TrimPrefix(line, "new mode ")
+            changes = append(changes, current)
+        case strings.HasPrefix(line, "new file mode "):
+            // Added files have no old mode
+            current.NewMode = strings.TrimPrefix(line, "new file mode ")
+            changes = append(changes, current)
+        }
+    }
+    return changes
+}
+
+// gainedExecutable reports whether a mode change adds an executable bit
+func (c modeChange) gainedExecutable() bool {
+    return !isExecutableMode(c.OldMode) && isExecutableMode(c.NewMode)
+}
+
+// isExecutableMode reports whether a git file mode like 100755 has any executable bit
+func isExecutableMode(mode string) bool {
+    if len(mode) < 3 {
+        return false
+    }
+    for _, c := range mode[len(mode)-3:] {
+        if (c-'0')&1 == 1 {
+            return true
+        }
+    }
+    return false
+}
+
+// patchChanges returns the added and removed lines of a unified diff patch, without their +/- prefix
+func patchChanges(patch string) (added, removed []string) {
+    for _, line := range strings.Split(patch, "\n") {
+        switch {
+        case strings.HasPrefix(line, "+++ "), strings.HasPrefix(line, "--- "):
+        case strings.HasPrefix(line, "+"):
+            added = append(added, line[1:])
+        case strings.HasPrefix(line, "-"):
+            removed = append(removed, line[1:])
+        }
+    }
+    return added, removed
+}
+
+// whitespaceOnly reports whether a patch changes lines but only in their whitespace
+func whitespaceOnly(patch string) bool {
+    added, removed := patchChanges(patch)
+    if len(added) == 0 && len(removed) == 0 {
+        return false
+    }
+    return stripWhitespace(added) == stripWhitespace(removed)
+}
+
+// stripWhitespace joins lines with all whitespace removed
+func stripWhitespace(lines []string) string {
+    var b strings.Builder
+    for _, line := range lines {
+        for _, r := range line {
+            if !unicode.IsSpace(r) {
+                b.WriteRune(r)
+            }
+        }
+    }
+    return b.String()
+}
+
+// filePatches splits a git diff into each file's hunks, keyed by the file's new path,
+// in the form the files API uses for PRFile.Patch
+func filePatches(diff string) map[string]string {
+    patches := make(map[string]string)
+    var current string
+    var hunks []string
+    inHunks := false
+    flush := func() {
+        if current != "" && len(hunks) > 0 {
+            patches[current] = strings.Join(hunks, "\n")
+        }
+        hunks = nil
+        inHunks = false
+    }
+    for _, line := range strings.Split(diff, "\n") {
+        switch {
+        case strings.HasPrefix(line, "diff --git "):
+            flush()
+            current = ""
+            if i := strings.Index(line, " b/"); i >= 0 {
+                current = line[i+len(" b/"):]
+            }
+        case strings.HasPrefix(line, "@@"):
+            inHunks = true
+            hunks = append(hunks, line)
+        case inHunks:
+            hunks = append(hunks, line)
+        }
+    }
+    flush()
+    return patches
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestParseModeChanges(t *testing.T) {
+    diff := `diff --git a/run.sh b/run.sh
+old mode 100644
+new mode 100755
+diff --git a/new.sh b/new.sh
+new file mode 100755
+index 0000000..e69de29
+diff --git a/new.txt b/new.txt
+new file mode 100644
+index 0000000..e69de29
+diff --git a/gone.sh b/gone.sh
+deleted file mode 100755
+index e69de29..0000000
+`
+    var executable []string
+    for _, c := range parseModeChanges(diff) {
+        if c.gainedExecutable() {
+            executable = append(executable, c.Filename)
+        }
+    }
+    if len(executable) != 2 || executable[0] != "run.sh" || executable[1] != "new.sh" {
+        t.Errorf("got executable files %v, want [run.sh new.sh]", executable)
+    }
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "regexp"
+)
+
+// FileRules are the per-file checks validatePR applies, read from the JSON file at RULES_PATH.
+// Forbidden filenames are the forbidden-files rule's, set by forbidden_files in the config.
+type FileRules struct {
+    // ForbiddenPathRegexes are regexes no changed path may match
+    ForbiddenPathRegexes []string `json:"forbidden_path_regexes"`
+    // MaxAdditionsPerFile caps the lines added to any one file (0 disables the check)
+    MaxAdditionsPerFile int `json:"max_additions_per_file"`
+    // MaxChangesPerFile caps the lines added plus deleted in any one file (0 disables the check)
+    MaxChangesPerFile int `json:"max_changes_per_file"`
+    // MaxChangedFiles caps how many files one PR may change (0, the default, means unlimited;
+    // MAX_CHANGED_FILES overrides it)
+    MaxChangedFiles int `json:"max_changed_files"`
+    // RejectBinaryFiles fails added or modified files with changes but no patch, which is how
+    // GitHub reports binaries
+    RejectBinaryFiles bool `json:"reject_binary_files"`
+    // BinaryExtensions are extensions, like ".png", always treated as binary
+    BinaryExtensions []string `json:"binary_extensions"`
+    // TextExtensions are extensions never treated as binary, since GitHub also omits the patch
+    // of text files too large to diff (default defaultTextExtensions)
+    TextExtensions []string `json:"text_extensions"`
+    // AllowedBinaryPaths are paths or globs exempt from both binary checks
+    AllowedBinaryPaths []string `json:"allowed_binary_paths"`
+
+    pathRes []*regexp.Regexp
+}
+
+// defaultTextExtensions are the text_extensions used when the rules file sets none
+var defaultTextExtensions = []string{".json", ".yaml", ".yml", ".sql", ".csv", ".txt", ".md", ".js", ".xml", ".conf"}
+
+// fileRules are the rules validatePR evaluates
+var fileRules = FileRules{TextExtensions: defaultTextExtensions}
+
+// loadFileRules reads the rules file at path. Unless required, a missing file means no file rules.
+func loadFileRules(path string, required bool) (FileRules, error) {
+    data, err := ioutil.ReadFile(path)
+    if os.IsNotExist(err) && !required {
+        return fileRules, nil
+    }
+    if err != nil {
+        return FileRules{}, err
+    }
+    var fr FileRules
+    if err := json.Unmarshal(data, &fr); err != nil {
+        return FileRules{}, err
+    }
+    var moved struct {
+        ForbiddenGlobs []string `json:"forbidden_globs"`
+    }
+    if json.Unmarshal(data, &moved) == nil && moved.ForbiddenGlobs != nil {
+        return FileRules{}, fmt.Errorf("forbidden_globs has moved to forbidden_files in the config")
+    }
+    if fr.TextExtensions == nil {
+        fr.TextExtensions = defaultTextExtensions
+    }
+    for _, p := range fr.ForbiddenPathRegexes {
+        re, err := regexp.Compile(p)
+        if err != nil {
+            return FileRules{}, fmt.Errorf("forbidden_path_regexes: %v", err)
+        }
+        fr.pathRes = append(fr.pathRes, re)
+    }
+    return fr, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "io/ioutil"
+    "log"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// useFileRules loads rules from JSON for the rest of the test
+func useFileRules(t *testing.T, rulesJSON string) {
+    t.Helper()
+    path := filepath.Join(t.TempDir(), "rules.json")
+    if err := ioutil.WriteFile(path, []byte(rulesJSON), 0644); err != nil {
+        t.Fatal(err)
+    }
+    fr, err := loadFileRules(path, true)
+    if err != nil {
+        t.Fatal(err)
+    }
+    saved := fileRules
+    fileRules = fr
+    t.Cleanup(func() { fileRules = saved })
+}
+
+func TestValidatePRFileRules(t *testing.T) {
+    useFileRules(t, `{"forbidden_path_regexes": ["^app/[^/]+/tmp/"], "max_additions_per_file": 100}`)
+
+    tests := []struct {
+        name string
+        file PRFile
+        want string
+    }{
+        {"path regex", PRFile{Filename: "app/billing/tmp/out.log"}, "matches forbidden path pattern"},
+        {"additions limit", PRFile{Filename: "app/billing/big.yaml", Additions: 101}, "adds 101 lines, limit is 100"},
+        {"removed files are allowed", PRFile{Filename: "app/billing/tmp/old.log", Status: "removed"}, ""},
+    }
+    for _, tt := range tests {
+        got := validatePR([]PRFile{tt.file})
+        if tt.want == "" {
+            if len(got) != 0 {
+                t.Errorf("%s: unexpected violations %v", tt.name, got)
+            }
+            continue
+        }
+        if len(got) != 1 || !strings.Contains(got[0], tt.want) {
+            t.Errorf("%s: got %v, want one violation containing %q", tt.name, got, tt.want)
+        }
+    }
+}
+
+func TestValidatePRCleanPR(t *testing.T) {
+    useFileRules(t, `{"forbidden_path_regexes": ["/tmp/"], "max_additions_per_file": 100}`)
+    files := []PRFile{
+        {Filename: "app/billing/config.yaml", Status: "modified", Additions: 3, Changes: 5, Patch: "@@ -1,2 +1,3 @@"},
+        {Filename: "README.md", Status: "added", Additions: 10, Changes: 10, Patch: "@@ -0,0 +1,10 @@"},
+    }
+    if got := validatePR(files); len(got) != 0 {
+        t.Errorf("clean PR got violations %v", got)
+    }
+}
+
+func TestLoadFileRulesMissingFile(t *testing.T) {
+    missing := filepath.Join(t.TempDir(), "rules.json")
+    if _, err := loadFileRules(missing, false); err != nil {
+        t.Errorf("missing default rules file: %v", err)
+    }
+    if _, err := loadFileRules(missing, true); err == nil {
+        t.Error("missing RULES_PATH file loaded without an error")
+    }
+}
+
+func TestForbiddenFilesComeFromTheConfig(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "rules.json")
+    if err := ioutil.WriteFile(path, []byte(`{"forbidden_globs": ["*.pem"]}`), 0644); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := loadFileRules(path, true); err == nil || !strings.Contains(err.Error(), "forbidden_files") {
+        t.Errorf("rules file with forbidden_globs: err = %v, want it pointed at forbidden_files", err)
+    }
+
+    c, err := loadConfig(filepath.Join(t.TempDir(), "config.json"))
+    if err != nil || len(c.ForbiddenFiles) != 1 || c.ForbiddenFiles[0] != "forbidden.txt" {
+        t.Errorf("default forbidden_files = %q, %v; want [forbidden.txt]", c.ForbiddenFiles, err)
+    }
+    useConfig(t, Config{ForbiddenFiles: []string{"*.pem"}})
+    pc := &prContext{GitLab: true, Files: []PRFile{{Filename: "app/mod/server.pem", Status: "added"}}}
+    results := runRules(context.Background(), pc, []Rule{mustRule(t, "forbidden-files")})
+    if len(results) != 1 || len(results[0].Failures) != 1 || !strings.Contains(results[0].Failures[0], "adds forbidden file app/mod/server.pem") {
+        t.Errorf("GitLab forbidden file: got %+v", results)
+    }
+}
+
+// mustRule looks up a registered rule by name
+func mustRule(t *testing.T, name string) Rule {
+    t.Helper()
+    rule, ok := ruleByName(name)
+    if !ok {
+        t.Fatalf("no rule %s", name)
+    }
+    return rule
+}
+
+func TestFileRulesApplyToEveryPR(t *testing.T) {
+    useConfig(t, Config{})
+    useFakeGitHub(t, newFakeGitHub())
+    useFileRules(t, `{"max_changes_per_file": 1000}`)
+    pc := &prContext{
+        Owner: "o", Repo: "r", Number: 1,
+        Files: []PRFile{{Filename: "billing/web/bundle.min.js", Status: "added", Additions: 4000, Changes: 4000, Patch: "@@ -0,0 +1,4000 @@"}},
+    }
+    v := validateChanges(context.Background(), pc, &bytes.Buffer{}, log.New(ioutil.Discard, "", 0))
+    if v.Status != "failure" || len(v.FileViolations) != 1 || !strings.Contains(v.Description, "billing/web/bundle.min.js changes 4000 lines") {
+        t.Errorf("got %s %q, want a failure naming the file", v.Status, v.Description)
+    }
+}
+
+func TestBinaryFiles(t *testing.T) {
+    useFileRules(t, `{"reject_binary_files": true, "binary_extensions": [".PNG"], "allowed_binary_paths": ["docs/*"]}`)
+
+    tests := []struct {
+        file   PRFile
+        binary bool
+    }{
+        {PRFile{Filename: "app/mod/tool", Status: "added", Changes: 1}, true},
+        {PRFile{Filename: "app/mod/blob.dat", Status: "modified", Changes: 2}, true},
+        {PRFile{Filename: "app/mod/logo.png", Status: "modified", Additions: 1, Changes: 1, Patch: "@@"}, true},
+        // GitHub omits the patch of large text files too
+        {PRFile{Filename: "app/mod/huge.sql", Status: "added", Additions: 90000, Changes: 90000}, false},
+        // Empty files have neither a patch nor changes
+        {PRFile{Filename: "app/mod/.gitkeep", Status: "added"}, false},
+        {PRFile{Filename: "app/mod/__init__.py", Status: "added"}, false},
+        {PRFile{Filename: "app/mod/old.bin", Status: "removed", Changes: 1}, false},
+        {PRFile{Filename: "app/mod/moved.yaml", Status: "renamed", PreviousFilename: "app/mod/a.yaml"}, false},
+        {PRFile{Filename: "docs/diagram", Status: "added", Changes: 1}, false},
+    }
+    for _, tt := range tests {
+        got := validatePR([]PRFile{tt.file})
+        flagged := len(got) == 1 && strings.Contains(got[0], "is a binary file")
+        if flagged != tt.binary {
+            t.Errorf("%s: violations %v, want binary %t", tt.file.Filename, got, tt.binary)
+        }
+    }
+}
@@ -0,0 +1,899 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "log"
+    "math/rand"
+    "net/http"
+    "net/url"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    "unicode/utf8"
+)
+
+// dryRun logs GitHub requests that would change state instead of sending them
+var dryRun bool
+
+// githubAPIBase is the GitHub API root without a trailing slash (GITHUB_API_BASE), e.g.
+// https://github.mycorp.com/api/v3 for GitHub Enterprise
+var githubAPIBase = "https://api.github.com"
+
+// githubClient is shared by every GitHub API call. Its timeout (GITHUB_HTTP_TIMEOUT) covers
+// the whole exchange, including reading the response body.
+var githubClient = &http.Client{Timeout: 30 * time.Second}
+
+// maxBodyBytes caps how much of any GitHub response body is read
+var maxBodyBytes int64 = 10 << 20
+
+// errBodyTooLarge is returned when a GitHub response body exceeds maxBodyBytes
+var errBodyTooLarge = errors.New("GitHub response body exceeds size limit")
+
+// cappedReader reads at most remaining bytes, then fails with errBodyTooLarge if more remain
+type cappedReader struct {
+    r         io.Reader
+    remaining int64
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+    if c.remaining <= 0 {
+        var probe [1]byte
+        n, err := c.r.Read(probe[:])
+        if n > 0 {
+            return 0, errBodyTooLarge
+        }
+        return 0, err
+    }
+    if int64(len(p)) > c.remaining {
+        p = p[:c.remaining]
+    }
+    n, err := c.r.Read(p)
+    c.remaining -= int64(n)
+    return n, err
+}
+
+// capBody limits the response body to maxBodyBytes
+func capBody(resp *http.Response) {
+    resp.Body = struct {
+        io.Reader
+        io.Closer
+    }{&cappedReader{r: resp.Body, remaining: maxBodyBytes}, resp.Body}
+}
+
+// githubError is a non-success response from the GitHub API
+type githubError struct {
+    StatusCode int
+    Body       string
+}
+
+func (e *githubError) Error() string {
+    return fmt.Sprintf("GitHub API error (%d %s): %s", e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+// isNotFound reports whether err is a 404 from the GitHub API
+func isNotFound(err error) bool {
+    var ge *githubError
+    return errors.As(err, &ge) && ge.StatusCode == http.StatusNotFound
+}
+
+// githubRequest builds a GitHub API request bound to ctx, JSON-encoding body when non-nil
+func githubRequest(ctx context.Context, method, url string, body interface{}) (*http.Request, error) {
+    var buf *bytes.Buffer
+    if body != nil {
+        bodyBytes, err := json.Marshal(body)
+        if err != nil {
+            return nil, err
+        }
+        buf = bytes.NewBuffer(bodyBytes)
+    }
+    var req *http.Request
+    var err error
+    if buf != nil {
+        req, err = http.NewRequestWithContext(ctx, method, url, buf)
+    } else {
+        req, err = http.NewRequestWithContext(ctx, method, url, nil)
+    }
+    if err != nil {
+        return nil, err
+    }
+    if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+        req.Header.Set("Authorization", "token "+token)
+    }
+    req.Header.Set("Accept", "application/vnd.github.v3+json")
+    if body != nil {
+        req.Header.Set("Content-Type", "application/json")
+    }
+    return req, nil
+}
+
+// githubSend sends req and fails unless the response status is one of want. The
+// response is decoded into out: raw into a *[]byte, as JSON otherwise, and skipped
+// when out is nil. In dry-run mode only GET requests are sent; the rest are logged.
+func githubSend(req *http.Request, out interface{}, want ...int) error {
+    if dryRun && req.Method != "GET" {
+        logDryRun(req)
+        return nil
+    }
+    resp, err := githubDo(req, want...)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    switch o := out.(type) {
+    case nil:
+        return nil
+    case *[]byte:
+        *o, err = ioutil.ReadAll(resp.Body)
+        return err
+    default:
+        return json.NewDecoder(resp.Body).Decode(out)
+    }
+}
+
+// githubDo sends req and returns the response if its status is one of want; the
+// caller must close the body
+func githubDo(req *http.Request, want ...int) (*http.Response, error) {
+    resp, err := githubDoRetry(req)
+    if err != nil {
+        return nil, err
+    }
+    capBody(resp)
+    for _, code := range want {
+        if resp.StatusCode == code {
+            return resp, nil
+        }
+    }
+    defer resp.Body.Close()
+    body, _ := ioutil.ReadAll(resp.Body)
+    return nil, &githubError{StatusCode: resp.StatusCode, Body: string(body)}
+}
+
+// githubMaxRetries is how many times a request failing with a network error or 5xx is retried (GITHUB_MAX_RETRIES)
+var githubMaxRetries = 3
+
+// githubRetryBackoff is the wait before the first retry; it doubles for each one after (GITHUB_RETRY_BACKOFF)
+var githubRetryBackoff = time.Second
+
+// githubDoRetry sends req, retrying network errors and 5xx responses with exponential backoff
+// (1s, 2s, 4s, ...) plus jitter. 4xx responses are returned as is, and POSTs aren't resent
+// after a network error.
+func githubDoRetry(req *http.Request) (*http.Response, error) {
+    lg := loggerFrom(req.Context())
+    backoff := githubRetryBackoff
+    rateLimited := false
+    for attempt := 0; ; {
+        start := time.Now()
+        resp, err := githubClient.Do(req)
+        code := 0
+        if err == nil {
+            code = resp.StatusCode
+        }
+        observeGitHubRequest(req, code, time.Since(start))
+        // A rate-limited request is retried once, after waiting for the limit to reset
+        if err == nil && !rateLimited {
+            if wait, ok := rateLimitWait(resp); ok {
+                rateLimited = true
+                if wait > githubRateLimitMaxWait {
+                    wait = githubRateLimitMaxWait
+                }
+                lg.Printf("GitHub rate limit hit on %s %s, waiting %s before retrying", req.Method, req.URL, wait)
+                resp.Body.Close()
+                select {
+                case <-time.After(wait):
+                case <-req.Context().Done():
+                    return nil, req.Context().Err()
+                }
+                if err := rewindBody(req); err != nil {
+                    return nil, err
+                }
+                continue
+            }
+        }
+        if err == nil && resp.StatusCode < 500 {
+            return resp, nil
+        }
+        // A POST that failed in transit may still have been applied, and sending it again
+        // could post a second comment or status
+        if err != nil && req.Method == "POST" {
+            return nil, err
+        }
+        if attempt >= githubMaxRetries || (req.Body != nil && req.GetBody == nil) {
+            return resp, err
+        }
+        if err != nil {
+            lg.Printf("GitHub %s %s failed, retrying in %s: %v", req.Method, req.URL, backoff, err)
+        } else {
+            lg.Printf("GitHub %s %s returned %d, retrying in %s", req.Method, req.URL, resp.StatusCode, backoff)
+            resp.Body.Close()
+        }
+        wait := backoff
+        if backoff/2 > 0 {
+            wait += time.Duration(rand.Int63n(int64(backoff / 2)))
+        }
+        select {
+        case <-time.After(wait):
+        case <-req.Context().Done():
+            return nil, req.Context().Err()
+        }
+        backoff *= 2
+        attempt++
+        if err := rewindBody(req); err != nil {
+            return nil, err
+        }
+    }
+}
+
+// rewindBody resets req's body so it can be sent again
+func rewindBody(req *http.Request) error {
+    if req.GetBody == nil {
+        return nil
+    }
+    body, err := req.GetBody()
+    if err != nil {
+        return err
+    }
+    req.Body = body
+    return nil
+}
+
+// githubRateLimitMaxWait caps how long a rate-limited request waits before its retry (GITHUB_RATE_LIMIT_MAX_WAIT)
+var githubRateLimitMaxWait = time.Minute
+
+// rateLimitWait reports whether resp is a primary or secondary rate-limit response and how long
+// GitHub asks to wait, from Retry-After or else X-RateLimit-Reset
+func rateLimitWait(resp *http.Response) (time.Duration, bool) {
+    if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+        return 0, false
+    }
+    if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+        return time.Duration(secs) * time.Second, true
+    }
+    if resp.Header.Get("X-RateLimit-Remaining") != "0" {
+        return 0, false
+    }
+    reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+    if err != nil {
+        return 0, false
+    }
+    wait := time.Until(time.Unix(reset, 0))
+    if wait < 0 {
+        wait = 0
+    }
+    return wait, true
+}
+
+// githubGetAll GETs a list endpoint and every following page named by the Link header
+func githubGetAll[T any](ctx context.Context, url string) ([]T, error) {
+    var all []T
+    for url != "" {
+        req, err := githubRequest(ctx, "GET", url, nil)
+        if err != nil {
+            return nil, err
+        }
+        resp, err := githubDo(req, 200)
+        if err != nil {
+            return nil, err
+        }
+        var page []T
+        err = json.NewDecoder(resp.Body).Decode(&page)
+        resp.Body.Close()
+        if err != nil {
+            return nil, err
+        }
+        all = append(all, page...)
+        url = nextPageURL(resp.Header.Get("Link"))
+    }
+    return all, nil
+}
+
+// nextPageURL extracts the rel="next" URL from a Link header, or "" on the last page
+func nextPageURL(link string) string {
+    for _, part := range strings.Split(link, ",") {
+        sections := strings.Split(part, ";")
+        if len(sections) < 2 {
+            continue
+        }
+        for _, param := range sections[1:] {
+            if strings.TrimSpace(param) == `rel="next"` {
+                return strings.Trim(strings.TrimSpace(sections[0]), "<>")
+            }
+        }
+    }
+    return ""
+}
+
+// logDryRun logs the exact request that would have been sent, with credentials redacted
+func logDryRun(req *http.Request) {
+    var body []byte
+    if req.GetBody != nil {
+        if rc, err := req.GetBody(); err == nil {
+            body, _ = ioutil.ReadAll(rc)
+            rc.Close()
+        }
+    }
+    var headers []string
+    for name, values := range req.Header {
+        value := strings.Join(values, ", ")
+        if name == "Authorization" || name == "Private-Token" {
+            value = "[REDACTED]"
+        }
+        headers = append(headers, name+": "+value)
+    }
+    sort.Strings(headers)
+    log.Printf("DRY RUN: would send %s %s\n  headers: %s\n  body: %s", req.Method, req.URL, strings.Join(headers, "; "), body)
+}
+
+// selfTest checks the token authenticates against the GitHub API, logging the
+// authenticated login and the remaining rate-limit budget
+func selfTest() error {
+    req, err := githubRequest(context.Background(), "GET", githubAPIBase+"/user", nil)
+    if err != nil {
+        return err
+    }
+    var user struct {
+        Login string `json:"login"`
+    }
+    if err := githubSend(req, &user, 200); err != nil {
+        return fmt.Errorf("GET /user failed: %v", err)
+    }
+    req, err = githubRequest(context.Background(), "GET", githubAPIBase+"/rate_limit", nil)
+    if err != nil {
+        return err
+    }
+    var limits struct {
+        Resources struct {
+            Core struct {
+                Limit     int   `json:"limit"`
+                Remaining int   `json:"remaining"`
+                Reset     int64 `json:"reset"`
+            } `json:"core"`
+        } `json:"resources"`
+    }
+    if err := githubSend(req, &limits, 200); err != nil {
+        return fmt.Errorf("GET /rate_limit failed: %v", err)
+    }
+    core := limits.Resources.Core
+    log.Printf("GitHub self-test passed: authenticated as %s, %d/%d requests remaining until %s", user.Login, core.Remaining, core.Limit, time.Unix(core.Reset, 0).Format(time.RFC3339))
+    return nil
+}
+
+// Label represents a label attached to a PR
+type Label struct {
+    Name string `json:"name"`
+}
+
+// hasLabel reports whether labels contains one with the given name
+func hasLabel(labels []Label, name string) bool {
+    for _, l := range labels {
+        if l.Name == name {
+            return true
+        }
+    }
+    return false
+}
+
+// PRRepo identifies the repository on one side of a PR
+type PRRepo struct {
+    FullName string `json:"full_name"`
+    Owner    struct {
+        Login string `json:"login"`
+    } `json:"owner"`
+}
+
+// PRDetails holds the fields of a PR used by validation
+type PRDetails struct {
+    Number int    `json:"number"`
+    Body   string `json:"body"`
+    User   struct {
+        Login string `json:"login"`
+    } `json:"user"`
+    Labels []Label `json:"labels"`
+    Head   struct {
+        SHA  string `json:"sha"`
+        Ref  string `json:"ref"`
+        Repo PRRepo `json:"repo"`
+    } `json:"head"`
+    Base struct {
+        SHA  string `json:"sha"`
+        Ref  string `json:"ref"`
+        Repo PRRepo `json:"repo"`
+    } `json:"base"`
+}
+
+// fetchPRDetails gets a PR from the GitHub API
+func fetchPRDetails(ctx context.Context, owner, repo string, prNumber int) (*PRDetails, error) {
+    req, err := githubRequest(ctx, "GET", fmt.Sprintf(githubAPIBase+"/repos/%s/%s/pulls/%d", owner, repo, prNumber), nil)
+    if err != nil {
+        return nil, err
+    }
+    var details PRDetails
+    if err := githubSend(req, &details, 200); err != nil {
+        return nil, err
+    }
+    return &details, nil
+}
+
+// Review is a PR review
+type Review struct {
+    User struct {
+        Login string `json:"login"`
+    } `json:"user"`
+    State string `json:"state"`
+}
+
+// fetchPRReviews gets the reviews submitted on a PR
+func fetchPRReviews(ctx context.Context, owner, repo string, prNumber int) ([]Review, error) {
+    req, err := githubRequest(ctx, "GET", fmt.Sprintf(githubAPIBase+"/repos/%s/%s/pulls/%d/reviews?per_page=100", owner, repo, prNumber), nil)
+    if err != nil {
+        return nil, err
+    }
+    var reviews []Review
+    if err := githubSend(req, &reviews, 200); err != nil {
+        return nil, err
+    }
+    return reviews, nil
+}
+
+// approvedReviewers returns the users whose latest decisive review is an approval
+func approvedReviewers(reviews []Review) []string {
+    latest := make(map[string]string)
+    var order []string
+    for _, r := range reviews {
+        if r.State == "COMMENTED" || r.State == "PENDING" {
+            continue
+        }
+        if _, seen := latest[r.User.Login]; !seen {
+            order = append(order, r.User.Login)
+        }
+        latest[r.User.Login] = r.State
+    }
+    var approvers []string
+    for _, login := range order {
+        if latest[login] == "APPROVED" {
+            approvers = append(approvers, login)
+        }
+    }
+    return approvers
+}
+
+// isTeamMember reports whether user is an active member of org/team
+func isTeamMember(ctx context.Context, org, team, user string) (bool, error) {
+    req, err := githubRequest(ctx, "GET", fmt.Sprintf(githubAPIBase+"/orgs/%s/teams/%s/memberships/%s", org, team, user), nil)
+    if err != nil {
+        return false, err
+    }
+    var membership struct {
+        State string `json:"state"`
+    }
+    if err := githubSend(req, &membership, 200); err != nil {
+        if isNotFound(err) {
+            return false, nil
+        }
+        return false, err
+    }
+    return membership.State == "active", nil
+}
+
+// orgMembers caches isOrgMember results, keyed by "org/user", for orgMemberTTL
+var orgMembers = struct {
+    sync.Mutex
+    m map[string]orgMemberEntry
+}{m: make(map[string]orgMemberEntry)}
+
+type orgMemberEntry struct {
+    member bool
+    notOrg bool
+    at     time.Time
+}
+
+// errNotOrg is returned by isOrgMember when the owner is a user account, which has no members
+var errNotOrg = errors.New("owner is not an organization")
+
+// orgMemberTTL is how long an org membership lookup is cached
+const orgMemberTTL = 10 * time.Minute
+
+// isOrgMember reports whether user is an active member of org, caching the answer. It
+// returns errNotOrg when org is a user account, where membership doesn't apply.
+func isOrgMember(ctx context.Context, org, user string) (bool, error) {
+    key := strings.ToLower(org + "/" + user)
+    orgMembers.Lock()
+    e, ok := orgMembers.m[key]
+    orgMembers.Unlock()
+    if ok && time.Since(e.at) < orgMemberTTL {
+        if e.notOrg {
+            return false, errNotOrg
+        }
+        return e.member, nil
+    }
+    req, err := githubRequest(ctx, "GET", fmt.Sprintf(githubAPIBase+"/orgs/%s/memberships/%s", org, user), nil)
+    if err != nil {
+        return false, err
+    }
+    var membership struct {
+        State string `json:"state"`
+    }
+    notOrg := false
+    if err := githubSend(req, &membership, 200); isNotFound(err) {
+        // The memberships API 404s for non-members and for owners that aren't orgs alike
+        if notOrg, err = isUserAccount(ctx, org); err != nil {
+            return false, err
+        }
+    } else if err != nil {
+        return false, err
+    }
+    member := membership.State == "active"
+    orgMembers.Lock()
+    orgMembers.m[key] = orgMemberEntry{member: member, notOrg: notOrg, at: time.Now()}
+    orgMembers.Unlock()
+    if notOrg {
+        return false, errNotOrg
+    }
+    return member, nil
+}
+
+// isUserAccount reports whether login is a user rather than an organization
+func isUserAccount(ctx context.Context, login string) (bool, error) {
+    req, err := githubRequest(ctx, "GET", fmt.Sprintf(githubAPIBase+"/users/%s", login), nil)
+    if err != nil {
+        return false, err
+    }
+    var account struct {
+        Type string `json:"type"`
+    }
+    if err := githubSend(req, &account, 200); err != nil {
+        return false, err
+    }
+    return account.Type != "Organization", nil
+}
+
+// Commit is a commit on a PR
+type Commit struct {
+    SHA    string `json:"sha"`
+    Commit struct {
+        Message string `json:"message"`
+        Author  struct {
+            Name string    `json:"name"`
+            Date time.Time `json:"date"`
+        } `json:"author"`
+        // Committer.Date is when the commit was last rewritten, e.g. by a rebase or amend
+        Committer struct {
+            Date time.Time `json:"date"`
+        } `json:"committer"`
+        Verification struct {
+            Verified bool   `json:"verified"`
+            Reason   string `json:"reason"`
+        } `json:"verification"`
+    } `json:"commit"`
+    Parents []struct {
+        SHA string `json:"sha"`
+    } `json:"parents"`
+}
+
+// fetchPRCommits gets every commit on a PR
+func fetchPRCommits(ctx context.Context, owner, repo string, prNumber int) ([]Commit, error) {
+    return githubGetAll[Commit](ctx, fmt.Sprintf(githubAPIBase+"/repos/%s/%s/pulls/%d/commits?per_page=100", owner, repo, prNumber))
+}
+
+// pathHasHistory reports whether any commit reachable from ref touched path, meaning
+// the file existed there at some point
+func pathHasHistory(ctx context.Context, owner, repo, path, ref string) (bool, error) {
+    req, err := githubRequest(ctx, "GET", fmt.Sprintf(githubAPIBase+"/repos/%s/%s/commits?path=%s&sha=%s&per_page=1", owner, repo, url.QueryEscape(path), url.QueryEscape(ref)), nil)
+    if err != nil {
+        return false, err
+    }
+    var commits []Commit
+    if err := githubSend(req, &commits, 200); err != nil {
+        return false, err
+    }
+    return len(commits) > 0, nil
+}
+
+// Comparison is how a head commit relates to a base
+type Comparison struct {
+    Status   string `json:"status"`
+    AheadBy  int    `json:"ahead_by"`
+    BehindBy int    `json:"behind_by"`
+}
+
+// compareCommits compares head against base
+func compareCommits(ctx context.Context, owner, repo, base, head string) (*Comparison, error) {
+    req, err := githubRequest(ctx, "GET", fmt.Sprintf(githubAPIBase+"/repos/%s/%s/compare/%s...%s?per_page=1", owner, repo, url.PathEscape(base), url.PathEscape(head)), nil)
+    if err != nil {
+        return nil, err
+    }
+    var c Comparison
+    if err := githubSend(req, &c, 200); err != nil {
+        return nil, err
+    }
+    return &c, nil
+}
+
+// fetchPRDiff gets a PR's full unified diff
+func fetchPRDiff(ctx context.Context, owner, repo string, prNumber int) (string, error) {
+    req, err := githubRequest(ctx, "GET", fmt.Sprintf(githubAPIBase+"/repos/%s/%s/pulls/%d", owner, repo, prNumber), nil)
+    if err != nil {
+        return "", err
+    }
+    req.Header.Set("Accept", "application/vnd.github.v3.diff")
+    var diff []byte
+    if err := githubSend(req, &diff, 200); err != nil {
+        return "", err
+    }
+    return string(diff), nil
+}
+
+// fetchTree gets the set of file paths in the repo tree at a commit
+func fetchTree(ctx context.Context, owner, repo, sha string) (map[string]bool, error) {
+    req, err := githubRequest(ctx, "GET", fmt.Sprintf(githubAPIBase+"/repos/%s/%s/git/trees/%s?recursive=1", owner, repo, sha), nil)
+    if err != nil {
+        return nil, err
+    }
+    var tree struct {
+        Tree []struct {
+            Path string `json:"path"`
+            Type string `json:"type"`
+        } `json:"tree"`
+        Truncated bool `json:"truncated"`
+    }
+    if err := githubSend(req, &tree, 200); err != nil {
+        return nil, err
+    }
+    if tree.Truncated {
+        loggerFrom(ctx).Printf("Tree for %s/%s@%s was truncated by GitHub, results may be incomplete", owner, repo, sha)
+    }
+    paths := make(map[string]bool)
+    for _, e := range tree.Tree {
+        if e.Type == "blob" {
+            paths[e.Path] = true
+        }
+    }
+    return paths, nil
+}
+
+// updatePRStatus posts a status to the PR using the GitHub API
+func updatePRStatus(ctx context.Context, owner, repo string, prNumber int, state, description, targetURL string) error {
+    // Get PR details to find the head SHA
+    details, err := fetchPRDetails(ctx, owner, repo, prNumber)
+    if err != nil {
+        return err
+    }
+    if err := postCommitStatus(ctx, owner, repo, details.Head.SHA, "commitvalidator", state, description, targetURL); err != nil {
+        return err
+    }
+    loggerFrom(ctx).Printf("PR #%d [%s/%s] status updated to %s: %s", prNumber, owner, repo, state, description)
+    return nil
+}
+
+// truncateRunes shortens s to at most n characters, ending in "..." when cut, without
+// splitting a multi-byte character
+func truncateRunes(s string, n int) string {
+    if utf8.RuneCountInString(s) <= n {
+        return s
+    }
+    r := []rune(s)
+    return string(r[:n-3]) + "..."
+}
+
+// postCommitStatus sets a status with the given context on a commit, linking to targetURL when set
+func postCommitStatus(ctx context.Context, owner, repo, sha, statusContext, state, description, targetURL string) error {
+    // Commit statuses have no neutral state; neutral results pass and say why in the description
+    if state == "neutral" {
+        state = "success"
+    }
+    // GitHub rejects status descriptions longer than 140 characters
+    description = truncateRunes(description, 140)
+    statusBody := map[string]string{
+        "state": state,
+        "description": description,
+        "context": statusContext,
+    }
+    if targetURL != "" {
+        statusBody["target_url"] = targetURL
+    }
+    req, err := githubRequest(ctx, "POST", fmt.Sprintf(githubAPIBase+"/repos/%s/%s/statuses/%s", owner, repo, sha), statusBody)
+    if err != nil {
+        return err
+    }
+    return githubSend(req, nil, 201)
+}
+
+// closePullRequest closes the PR using the GitHub API
+func closePullRequest(ctx context.Context, owner, repo string, prNumber int) error {
+    body := map[string]string{"state": "closed"}
+    req, err := githubRequest(ctx, "PATCH", fmt.Sprintf(githubAPIBase+"/repos/%s/%s/pulls/%d", owner, repo, prNumber), body)
+    if err != nil {
+        return err
+    }
+    if err := githubSend(req, nil, 200); err != nil {
+        return err
+    }
+    recordClose(owner, repo, prNumber)
+    loggerFrom(ctx).Printf("PR #%d [%s/%s] has been closed after validation.", prNumber, owner, repo)
+    return nil
+}
+
+// reopenPullRequest reopens a closed PR
+func reopenPullRequest(ctx context.Context, owner, repo string, prNumber int) error {
+    body := map[string]string{"state": "open"}
+    req, err := githubRequest(ctx, "PATCH", fmt.Sprintf(githubAPIBase+"/repos/%s/%s/pulls/%d", owner, repo, prNumber), body)
+    if err != nil {
+        return err
+    }
+    return githubSend(req, nil, 200)
+}
+
+// postPRComment adds a comment to a PR's conversation
+func postPRComment(ctx context.Context, owner, repo string, prNumber int, body string) error {
+    req, err := githubRequest(ctx, "POST", fmt.Sprintf(githubAPIBase+"/repos/%s/%s/issues/%d/comments", owner, repo, prNumber), map[string]string{"body": body})
+    if err != nil {
+        return err
+    }
+    return githubSend(req, nil, 201)
+}
+
+// postPRReviewComment submits a review on a PR that only comments, without approving or requesting changes
+func postPRReviewComment(ctx context.Context, owner, repo string, prNumber int, body string) error {
+    review := map[string]string{"body": body, "event": "COMMENT"}
+    req, err := githubRequest(ctx, "POST", fmt.Sprintf(githubAPIBase+"/repos/%s/%s/pulls/%d/reviews", owner, repo, prNumber), review)
+    if err != nil {
+        return err
+    }
+    return githubSend(req, nil, 200)
+}
+
+// IssueComment is a comment in a PR's conversation
+type IssueComment struct {
+    ID   int64  `json:"id"`
+    Body string `json:"body"`
+}
+
+// upsertPRComment edits the PR (or issue) comment containing marker, or adds one when there is none,
+// so re-validations update a single comment. The marker is appended to body.
+func upsertPRComment(ctx context.Context, owner, repo string, prNumber int, marker, body string) error {
+    comments, err := githubGetAll[IssueComment](ctx, fmt.Sprintf(githubAPIBase+"/repos/%s/%s/issues/%d/comments?per_page=100", owner, repo, prNumber))
+    if err != nil {
+        return err
+    }
+    body += "\n" + marker
+    for _, c := range comments {
+        if strings.Contains(c.Body, marker) {
+            req, err := githubRequest(ctx, "PATCH", fmt.Sprintf(githubAPIBase+"/repos/%s/%s/issues/comments/%d", owner, repo, c.ID), map[string]string{"body": body})
+            if err != nil {
+                return err
+            }
+            return githubSend(req, nil, 200)
+        }
+    }
+    return postPRComment(ctx, owner, repo, prNumber, body)
+}
+
+// addLabels applies labels to a PR through the issues API
+func addLabels(ctx context.Context, owner, repo string, prNumber int, labels []string) error {
+    body := map[string][]string{"labels": labels}
+    req, err := githubRequest(ctx, "POST", fmt.Sprintf(githubAPIBase+"/repos/%s/%s/issues/%d/labels", owner, repo, prNumber), body)
+    if err != nil {
+        return err
+    }
+    return githubSend(req, nil, 200)
+}
+
+// PRFile represents a file changed in a PR
+type PRFile struct {
+    Filename         string `json:"filename"`
+    PreviousFilename string `json:"previous_filename"`
+    Additions        int    `json:"additions"`
+    Deletions        int    `json:"deletions"`
+    Changes          int    `json:"changes"`
+    Status           string `json:"status"`
+    RawURL           string `json:"raw_url"`
+    BlobURL          string `json:"blob_url"`
+    Patch            string `json:"patch"`
+}
+
+// fetchFileContent gets a file's content at ref through the contents API. Files too
+// large for the contents API to inline (over 1MB) are fetched through the blob API.
+func fetchFileContent(ctx context.Context, owner, repo, path, ref string) ([]byte, error) {
+    var segments []string
+    for _, seg := range strings.Split(path, "/") {
+        segments = append(segments, url.PathEscape(seg))
+    }
+    req, err := githubRequest(ctx, "GET", fmt.Sprintf(githubAPIBase+"/repos/%s/%s/contents/%s?ref=%s", owner, repo, strings.Join(segments, "/"), url.QueryEscape(ref)), nil)
+    if err != nil {
+        return nil, err
+    }
+    var file struct {
+        Type     string `json:"type"`
+        Encoding string `json:"encoding"`
+        Content  string `json:"content"`
+        SHA      string `json:"sha"`
+        Size     int    `json:"size"`
+    }
+    if err := githubSend(req, &file, 200); err != nil {
+        return nil, err
+    }
+    if file.Type != "file" {
+        return nil, fmt.Errorf("%s at %s is a %s, not a file", path, ref, file.Type)
+    }
+    if file.Encoding == "base64" {
+        return decodeBase64Content(file.Content)
+    }
+    if file.Size == 0 {
+        return []byte{}, nil
+    }
+
+    // Too large for the contents API: it reports encoding "none" without content
+    req, err = githubRequest(ctx, "GET", fmt.Sprintf(githubAPIBase+"/repos/%s/%s/git/blobs/%s", owner, repo, file.SHA), nil)
+    if err != nil {
+        return nil, err
+    }
+    var blob struct {
+        Encoding string `json:"encoding"`
+        Content  string `json:"content"`
+    }
+    if err := githubSend(req, &blob, 200); err != nil {
+        return nil, err
+    }
+    if blob.Encoding != "base64" {
+        return nil, fmt.Errorf("unexpected blob encoding %q for %s", blob.Encoding, path)
+    }
+    return decodeBase64Content(blob.Content)
+}
+
+// decodeBase64Content decodes GitHub's line-wrapped base64 content
+func decodeBase64Content(content string) ([]byte, error) {
+    return base64.StdEncoding.DecodeString(strings.ReplaceAll(content, "\n", ""))
+}
+
+// fetchPRFiles gets the list of changed files for a PR from GitHub
+func fetchPRFiles(ctx context.Context, owner, repo string, prNumber int) ([]PRFile, error) {
+    // githubGetAll sends GITHUB_TOKEN when set, so private repos work, and follows the pagination
+    files, err := githubGetAll[PRFile](ctx, fmt.Sprintf(githubAPIBase+"/repos/%s/%s/pulls/%d/files?per_page=100", owner, repo, prNumber))
+    if err != nil {
+        return nil, err
+    }
+    files, dupes := dedupePRFiles(files)
+    if dupes > 0 {
+        loggerFrom(ctx).Printf("PR #%d [%s/%s] files API returned %d duplicate file entries, merged them", prNumber, owner, repo, dupes)
+    }
+    return files, nil
+}
+
+// dedupePRFiles merges entries with the same filename, keeping the first entry's
+// position and the largest stats and non-empty patch seen. It returns the merged
+// files and how many duplicates were dropped.
+func dedupePRFiles(files []PRFile) ([]PRFile, int) {
+    index := make(map[string]int)
+    var merged []PRFile
+    for _, f := range files {
+        i, seen := index[f.Filename]
+        if !seen {
+            index[f.Filename] = len(merged)
+            merged = append(merged, f)
+            continue
+        }
+        m := &merged[i]
+        if f.Additions > m.Additions {
+            m.Additions = f.Additions
+        }
+        if f.Deletions > m.Deletions {
+            m.Deletions = f.Deletions
+        }
+        if f.Changes > m.Changes {
+            m.Changes = f.Changes
+        }
+        if m.Patch == "" {
+            m.Patch = f.Patch
+        }
+    }
+    return merged, len(files) - len(merged)
+}
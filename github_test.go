@@ -0,0 +1,290 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// mockGitHub serves handler as the GitHub API for the rest of the test
+func mockGitHub(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+    t.Helper()
+    srv := httptest.NewServer(handler)
+    base, retries := githubAPIBase, githubMaxRetries
+    githubAPIBase, githubMaxRetries = srv.URL, 0
+    t.Cleanup(func() {
+        srv.Close()
+        githubAPIBase, githubMaxRetries = base, retries
+    })
+    return srv
+}
+
+// filePages serves each page of files in turn, linking every page but the last to the next
+func filePages(t *testing.T, pages ...[]PRFile) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != "/repos/o/r/pulls/1/files" {
+            t.Errorf("unexpected request %s", r.URL)
+            http.NotFound(w, r)
+            return
+        }
+        page := 0
+        fmt.Sscan(r.URL.Query().Get("page"), &page)
+        if page < len(pages)-1 {
+            w.Header().Set("Link", fmt.Sprintf(`<http://%s%s?per_page=100&page=%d>; rel="next"`, r.Host, r.URL.Path, page+1))
+        }
+        json.NewEncoder(w).Encode(pages[page])
+    }
+}
+
+func TestFetchPRFilesFollowsNextLinks(t *testing.T) {
+    first := make([]PRFile, 100)
+    for i := range first {
+        first[i] = PRFile{Filename: fmt.Sprintf("app/mod/%03d.yaml", i)}
+    }
+    pages := filePages(t, first, []PRFile{{Filename: "app/mod/last.yaml"}})
+    mockGitHub(t, func(w http.ResponseWriter, r *http.Request) {
+        if n := r.URL.Query().Get("per_page"); n != "100" {
+            t.Errorf("per_page = %q, want 100", n)
+        }
+        pages(w, r)
+    })
+
+    files, err := fetchPRFiles(context.Background(), "o", "r", 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(files) != 101 || files[100].Filename != "app/mod/last.yaml" {
+        t.Errorf("got %d files, want all 101 across both pages", len(files))
+    }
+}
+
+func TestFetchPRFilesMergesDuplicatesAcrossPages(t *testing.T) {
+    mockGitHub(t, filePages(t,
+        []PRFile{{Filename: "app/mod/a.yaml", Additions: 1, Changes: 1}, {Filename: "app/mod/b.yaml", Additions: 2, Changes: 2}},
+        []PRFile{{Filename: "app/mod/b.yaml", Additions: 5, Changes: 5, Patch: "@@ -0,0 +1 @@"}},
+    ))
+
+    files, err := fetchPRFiles(context.Background(), "o", "r", 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(files) != 2 {
+        t.Fatalf("got %d files, want 2: %+v", len(files), files)
+    }
+    b := files[1]
+    if b.Filename != "app/mod/b.yaml" || b.Additions != 5 || b.Changes != 5 || b.Patch == "" {
+        t.Errorf("duplicate entries not merged: %+v", b)
+    }
+}
+
+func TestFetchFileContent(t *testing.T) {
+    mockGitHub(t, func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/repos/o/r/contents/dir/small.txt":
+            if ref := r.URL.Query().Get("ref"); ref != "refs/pull/1/head" {
+                t.Errorf("ref = %q", ref)
+            }
+            // The contents API wraps base64 content at 60 characters
+            fmt.Fprint(w, `{"type":"file","encoding":"base64","content":"aGVsbG8g\nd29ybGQ=\n","sha":"s1","size":11}`)
+        case "/repos/o/r/contents/big.bin":
+            fmt.Fprint(w, `{"type":"file","encoding":"none","content":"","sha":"s2","size":2000000}`)
+        case "/repos/o/r/git/blobs/s2":
+            fmt.Fprint(w, `{"encoding":"base64","content":"YmlnIGZpbGU="}`)
+        case "/repos/o/r/contents/dir":
+            fmt.Fprint(w, `{"type":"dir"}`)
+        default:
+            http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+        }
+    })
+
+    tests := []struct {
+        path, want string
+        wantErr    bool
+    }{
+        {path: "dir/small.txt", want: "hello world"},
+        {path: "big.bin", want: "big file"},
+        {path: "dir", wantErr: true},
+        {path: "missing.txt", wantErr: true},
+    }
+    for _, tt := range tests {
+        got, err := fetchFileContent(context.Background(), "o", "r", tt.path, "refs/pull/1/head")
+        if tt.wantErr {
+            if err == nil {
+                t.Errorf("%s: expected an error, got %q", tt.path, got)
+            }
+            continue
+        }
+        if err != nil || string(got) != tt.want {
+            t.Errorf("%s: got %q, %v; want %q", tt.path, got, err, tt.want)
+        }
+    }
+
+    _, err := fetchFileContent(context.Background(), "o", "r", "missing.txt", "main")
+    if !isNotFound(err) {
+        t.Errorf("missing file error %v is not a not-found error", err)
+    }
+}
+
+func TestGitHubClientTimeout(t *testing.T) {
+    mockGitHub(t, func(w http.ResponseWriter, r *http.Request) {
+        select {
+        case <-time.After(5 * time.Second):
+        case <-r.Context().Done():
+        }
+    })
+    defer func(d time.Duration) { githubClient.Timeout = d }(githubClient.Timeout)
+    githubClient.Timeout = 50 * time.Millisecond
+
+    start := time.Now()
+    _, err := fetchPRFiles(context.Background(), "o", "r", 1)
+    var ne net.Error
+    if !errors.As(err, &ne) || !ne.Timeout() {
+        t.Fatalf("got %v, want a timeout error", err)
+    }
+    if elapsed := time.Since(start); elapsed > 2*time.Second {
+        t.Errorf("request took %s despite the 50ms timeout", elapsed)
+    }
+}
+
+func TestGitHubRetriesTransientFailures(t *testing.T) {
+    var calls int32
+    mockGitHub(t, func(w http.ResponseWriter, r *http.Request) {
+        if atomic.AddInt32(&calls, 1) <= 2 {
+            http.Error(w, `{"message":"Bad Gateway"}`, http.StatusBadGateway)
+            return
+        }
+        fmt.Fprint(w, `[{"filename":"app/mod/a.yaml"}]`)
+    })
+    defer func(n int, d time.Duration) { githubMaxRetries, githubRetryBackoff = n, d }(githubMaxRetries, githubRetryBackoff)
+    githubMaxRetries, githubRetryBackoff = 3, time.Millisecond
+
+    files, err := fetchPRFiles(context.Background(), "o", "r", 1)
+    if err != nil || len(files) != 1 {
+        t.Fatalf("got %v, %v after two failures; want the file from the third attempt", files, err)
+    }
+    if n := atomic.LoadInt32(&calls); n != 3 {
+        t.Errorf("server called %d times, want 3", n)
+    }
+}
+
+func TestGitHubDoesNotRetryClientErrorsOrFailedPosts(t *testing.T) {
+    var calls int32
+    srv := mockGitHub(t, func(w http.ResponseWriter, r *http.Request) {
+        atomic.AddInt32(&calls, 1)
+        if r.Method == "POST" {
+            // Drop the connection, as a reset after GitHub received the request would
+            conn, _, _ := w.(http.Hijacker).Hijack()
+            conn.Close()
+            return
+        }
+        http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+    })
+    defer func(n int, d time.Duration) { githubMaxRetries, githubRetryBackoff = n, d }(githubMaxRetries, githubRetryBackoff)
+    githubMaxRetries, githubRetryBackoff = 3, time.Millisecond
+
+    if _, err := fetchPRFiles(context.Background(), "o", "r", 1); !isNotFound(err) || atomic.LoadInt32(&calls) != 1 {
+        t.Errorf("404: got %v after %d calls, want a not-found error after 1", err, atomic.LoadInt32(&calls))
+    }
+    atomic.StoreInt32(&calls, 0)
+    req, err := githubRequest(context.Background(), "POST", srv.URL+"/repos/o/r/issues/1/comments", map[string]string{"body": "hi"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := githubSend(req, nil, 201); err == nil || atomic.LoadInt32(&calls) != 1 {
+        t.Errorf("failed POST: got %v after %d calls, want an error after 1", err, atomic.LoadInt32(&calls))
+    }
+}
+
+func TestGitHubFetchStopsWhenContextIsDone(t *testing.T) {
+    release := make(chan struct{})
+    defer close(release)
+    mockGitHub(t, func(w http.ResponseWriter, r *http.Request) {
+        select {
+        case <-release:
+        case <-r.Context().Done():
+        }
+    })
+    ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+    defer cancel()
+
+    start := time.Now()
+    if _, err := fetchPRDetails(ctx, "o", "r", 1); !errors.Is(err, context.DeadlineExceeded) {
+        t.Errorf("err = %v, want context.DeadlineExceeded", err)
+    }
+    if d := time.Since(start); d > 2*time.Second {
+        t.Errorf("fetch returned after %s, want it to stop at the deadline", d)
+    }
+}
+
+func TestIsOrgMemberOnUserOwnedRepo(t *testing.T) {
+    mockGitHub(t, func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/users/someuser":
+            fmt.Fprint(w, `{"type":"User"}`)
+        case "/users/someorg":
+            fmt.Fprint(w, `{"type":"Organization"}`)
+        default:
+            http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+        }
+    })
+
+    if _, err := isOrgMember(context.Background(), "someuser", "contributor"); !errors.Is(err, errNotOrg) {
+        t.Errorf("user-owned repo: err = %v, want errNotOrg", err)
+    }
+    if _, err := isOrgMember(context.Background(), "someuser", "contributor"); !errors.Is(err, errNotOrg) {
+        t.Errorf("cached user-owned repo: err = %v, want errNotOrg", err)
+    }
+    if member, err := isOrgMember(context.Background(), "someorg", "outsider"); err != nil || member {
+        t.Errorf("org non-member: got %t, %v, want false, nil", member, err)
+    }
+}
+
+func TestGitHubRetriesLogThroughTheDeliveryLogger(t *testing.T) {
+    var calls int32
+    mockGitHub(t, func(w http.ResponseWriter, r *http.Request) {
+        if atomic.AddInt32(&calls, 1) == 1 {
+            http.Error(w, "unavailable", http.StatusBadGateway)
+            return
+        }
+        fmt.Fprint(w, `{"number":1}`)
+    })
+    defer func(n int, d time.Duration) { githubMaxRetries, githubRetryBackoff = n, d }(githubMaxRetries, githubRetryBackoff)
+    githubMaxRetries, githubRetryBackoff = 1, time.Millisecond
+    var buf bytes.Buffer
+    ctx := withLogger(context.Background(), log.New(&buf, "report=abc ", 0))
+
+    if _, err := fetchPRDetails(ctx, "o", "r", 1); err != nil {
+        t.Fatal(err)
+    }
+    if !strings.Contains(buf.String(), "report=abc GitHub GET") {
+        t.Errorf("retry log = %q, want it on the delivery logger", buf.String())
+    }
+}
+
+func TestGitHubRetryWithTinyBackoff(t *testing.T) {
+    var calls int32
+    mockGitHub(t, func(w http.ResponseWriter, r *http.Request) {
+        if atomic.AddInt32(&calls, 1) == 1 {
+            http.Error(w, "unavailable", http.StatusBadGateway)
+            return
+        }
+        fmt.Fprint(w, `{"number":1}`)
+    })
+    defer func(n int, d time.Duration) { githubMaxRetries, githubRetryBackoff = n, d }(githubMaxRetries, githubRetryBackoff)
+    // A backoff too small to halve leaves no room for jitter
+    githubMaxRetries, githubRetryBackoff = 1, time.Nanosecond
+
+    if _, err := fetchPRDetails(context.Background(), "o", "r", 1); err != nil || atomic.LoadInt32(&calls) != 2 {
+        t.Errorf("got %v after %d calls, want success on the retry", err, atomic.LoadInt32(&calls))
+    }
+}
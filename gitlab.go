@@ -0,0 +1,220 @@
+package main
+
+import (
+    "context"
+    "crypto/subtle"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "log/slog"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+)
+
+// gitlabAPIBase is the GitLab API root (GITLAB_API_URL)
+var gitlabAPIBase = "https://gitlab.com/api/v4"
+
+// gitlabRequest builds a GitLab API request bound to ctx, sending GITLAB_TOKEN when set
+func gitlabRequest(ctx context.Context, method, path string, body interface{}) (*http.Request, error) {
+    req, err := githubRequest(ctx, method, strings.TrimRight(gitlabAPIBase, "/")+path, body)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Del("Authorization")
+    req.Header.Del("Accept")
+    if token := os.Getenv("GITLAB_TOKEN"); token != "" {
+        req.Header.Set("PRIVATE-TOKEN", token)
+    }
+    return req, nil
+}
+
+// fetchMRFiles gets a merge request's changed files, translated into PRFiles
+func fetchMRFiles(ctx context.Context, projectID, iid int) ([]PRFile, error) {
+    req, err := gitlabRequest(ctx, "GET", fmt.Sprintf("/projects/%d/merge_requests/%d/changes", projectID, iid), nil)
+    if err != nil {
+        return nil, err
+    }
+    var mr struct {
+        Changes []struct {
+            OldPath     string `json:"old_path"`
+            NewPath     string `json:"new_path"`
+            NewFile     bool   `json:"new_file"`
+            RenamedFile bool   `json:"renamed_file"`
+            DeletedFile bool   `json:"deleted_file"`
+            Diff        string `json:"diff"`
+        } `json:"changes"`
+    }
+    if err := githubSend(req, &mr, 200); err != nil {
+        return nil, err
+    }
+    var files []PRFile
+    for _, c := range mr.Changes {
+        f := PRFile{Filename: c.NewPath, Status: "modified", Patch: c.Diff}
+        switch {
+        case c.NewFile:
+            f.Status = "added"
+        case c.DeletedFile:
+            f.Status = "removed"
+        case c.RenamedFile:
+            f.Status, f.PreviousFilename = "renamed", c.OldPath
+        }
+        added, removed := patchChanges(c.Diff)
+        f.Additions, f.Deletions = len(added), len(removed)
+        f.Changes = f.Additions + f.Deletions
+        files = append(files, f)
+    }
+    return files, nil
+}
+
+// fetchGitLabFile reads a file at ref from a GitLab project
+func fetchGitLabFile(ctx context.Context, projectID int, path, ref string) ([]byte, error) {
+    req, err := gitlabRequest(ctx, "GET", fmt.Sprintf("/projects/%d/repository/files/%s/raw?ref=%s", projectID, url.PathEscape(path), url.QueryEscape(ref)), nil)
+    if err != nil {
+        return nil, err
+    }
+    var content []byte
+    if err := githubSend(req, &content, 200); err != nil {
+        return nil, err
+    }
+    return content, nil
+}
+
+// postGitLabStatus sets the commitvalidator status on a commit of a GitLab project
+func postGitLabStatus(ctx context.Context, projectID int, sha, state, description string) error {
+    switch state {
+    case "failure":
+        state = "failed"
+    case "neutral":
+        state = "success"
+    }
+    description = truncateRunes(description, 140)
+    body := map[string]string{"state": state, "name": "commitvalidator", "description": description}
+    req, err := gitlabRequest(ctx, "POST", fmt.Sprintf("/projects/%d/statuses/%s", projectID, sha), body)
+    if err != nil {
+        return err
+    }
+    return githubSend(req, nil, 201)
+}
+
+// gitlabWebhookHandler validates GitLab merge requests through the same flow as GitHub PRs
+// and reports the result as a commit status. GITLAB_WEBHOOK_SECRET, when set, must match
+// the X-Gitlab-Token header.
+func gitlabWebhookHandler(w http.ResponseWriter, r *http.Request) {
+    if secret := os.Getenv("GITLAB_WEBHOOK_SECRET"); secret != "" {
+        if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Gitlab-Token")), []byte(secret)) != 1 {
+            log.Printf("Rejected GitLab webhook with a missing or invalid token")
+            http.Error(w, "Invalid token", http.StatusUnauthorized)
+            return
+        }
+    }
+    payload, err := ioutil.ReadAll(r.Body)
+    if err != nil {
+        http.Error(w, "Could not read request body", http.StatusInternalServerError)
+        return
+    }
+    rep := &webhookReport{}
+    defer rep.send(w, r)
+
+    var event struct {
+        ObjectKind string `json:"object_kind"`
+        User       struct {
+            Username string `json:"username"`
+        } `json:"user"`
+        Project struct {
+            ID                int    `json:"id"`
+            PathWithNamespace string `json:"path_with_namespace"`
+        } `json:"project"`
+        ObjectAttributes struct {
+            IID          int    `json:"iid"`
+            Action       string `json:"action"`
+            Description  string `json:"description"`
+            SourceBranch string `json:"source_branch"`
+            TargetBranch string `json:"target_branch"`
+            LastCommit   struct {
+                ID string `json:"id"`
+            } `json:"last_commit"`
+        } `json:"object_attributes"`
+        Labels []struct {
+            Title string `json:"title"`
+        } `json:"labels"`
+    }
+    if err := json.Unmarshal(payload, &event); err != nil || event.ObjectKind != "merge_request" {
+        fmt.Fprintf(rep, "Webhook received, but it is not a merge request event")
+        return
+    }
+    attrs := event.ObjectAttributes
+    webhooksReceived.Inc("merge_request", attrs.Action)
+    reportID := newReportID(r.Header.Get("X-Gitlab-Event-UUID"), attrs.LastCommit.ID)
+    rep.ReportID = reportID
+
+    // open, reopen and update (new commits or edits) map onto opened, reopened and synchronize
+    actions := map[string]string{"open": "opened", "reopen": "reopened", "update": "synchronize"}
+    project := event.Project.PathWithNamespace
+    owner, repo := "", project
+    if i := strings.LastIndex(project, "/"); i >= 0 {
+        owner, repo = project[:i], project[i+1:]
+    }
+    ctx, lg := deliveryLogger(context.WithoutCancel(r.Context()), slog.String("report_id", reportID), slog.String("delivery_id", r.Header.Get("X-Gitlab-Event-UUID")),
+        slog.String("owner", owner), slog.String("repo", repo), slog.Int("pr_number", attrs.IID))
+    if attrs.Action == "close" || attrs.Action == "merge" {
+        forgetImpacted(prKey(owner, repo, attrs.IID))
+    }
+    action, ok := actions[attrs.Action]
+    debugf(ctx, "Received GitLab merge request event with action %q, handled: %t", attrs.Action, ok)
+    if !ok {
+        fmt.Fprintf(rep, "Ignoring merge request event with action: %s", attrs.Action)
+        return
+    }
+
+    recordRepo(owner, repo)
+    lg.Printf("Merge request !%d %s for project %s", attrs.IID, action, project)
+
+    files, err := fetchMRFiles(ctx, event.Project.ID, attrs.IID)
+    if err != nil {
+        lg.Printf("Error fetching merge request changes: %v", err)
+        fmt.Fprintf(rep, "Error fetching merge request changes")
+        return
+    }
+
+    details := &PRDetails{Number: attrs.IID, Body: attrs.Description}
+    details.User.Login = event.User.Username
+    details.Head.SHA, details.Head.Ref = attrs.LastCommit.ID, attrs.SourceBranch
+    details.Base.Ref = attrs.TargetBranch
+    var labels []Label
+    for _, l := range event.Labels {
+        labels = append(labels, Label{Name: l.Title})
+    }
+    projectID := event.Project.ID
+    pc := &prContext{
+        Owner:           owner,
+        Repo:            repo,
+        Number:          attrs.IID,
+        Action:          action,
+        Labels:          labels,
+        Files:           files,
+        ReportID:        reportID,
+        Details:         details,
+        GitLab:          true,
+        AppServers:      make(map[string]map[string]bool),
+        BaseAppServers:  make(map[string]map[string]bool),
+        ImpactedServers: make(map[string]bool),
+        ProdServers:     make(map[string]bool),
+        fetchFile: func(ctx context.Context, path, ref string) ([]byte, error) {
+            return fetchGitLabFile(ctx, projectID, path, ref)
+        },
+    }
+
+    v := validateChanges(ctx, pc, rep, lg)
+    if err := postGitLabStatus(ctx, projectID, details.Head.SHA, v.Status, v.Description); err != nil {
+        lg.Printf("Error updating merge request status: %v", err)
+    }
+    swapImpacted(prKey(owner, repo, attrs.IID), pc.ImpactedServers)
+    emitValidationEvent(owner, repo, attrs.IID, reportID, v.Profile, v.Status, v.Results)
+    recordValidation(pc, v)
+    rep.PR, rep.Status, rep.Description = attrs.IID, v.Status, v.Description
+    rep.Violations, rep.Warnings, rep.Pending = v.Violations, v.Warnings, v.Pending
+    fmt.Fprintf(rep, "Merge request !%d validation complete. Status: %s\n", attrs.IID, v.Status)
+}
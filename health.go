@@ -0,0 +1,20 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "os"
+)
+
+// healthzHandler answers liveness probes without calling GitHub. It also reports whether
+// GITHUB_TOKEN is set and the local apps.json exists, so readiness checks can catch
+// misconfiguration.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+    _, err := os.Stat(appsJsonPath)
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(struct {
+        Status      string `json:"status"`
+        GitHubToken bool   `json:"github_token"`
+        AppsJson    bool   `json:"apps_json"`
+    }{"ok", os.Getenv("GITHUB_TOKEN") != "", err == nil})
+}
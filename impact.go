@@ -0,0 +1,212 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "sync"
+)
+
+// reportMarker identifies the validation summary comment
+const reportMarker = "<!-- commitvalidator -->"
+
+// statusFallbackMarker identifies the comment posted when the status couldn't be
+const statusFallbackMarker = "<!-- commitvalidator:status -->"
+
+// impactDeltaMarker identifies the impacted-server delta comment
+const impactDeltaMarker = "<!-- commitvalidator:impact-delta -->"
+
+// lastImpacted remembers the impacted servers computed for each open PR at its last validation
+var lastImpacted = struct {
+    sync.Mutex
+    m map[string]map[string]bool
+}{m: make(map[string]map[string]bool)}
+
+// swapImpacted records the PR's impacted servers and returns the previous set, if any
+func swapImpacted(key string, servers map[string]bool) (map[string]bool, bool) {
+    lastImpacted.Lock()
+    defer lastImpacted.Unlock()
+    prev, ok := lastImpacted.m[key]
+    lastImpacted.m[key] = servers
+    return prev, ok
+}
+
+// forgetImpacted drops a PR that is no longer open
+func forgetImpacted(key string) {
+    lastImpacted.Lock()
+    defer lastImpacted.Unlock()
+    delete(lastImpacted.m, key)
+}
+
+// overlappingPRs returns the other tracked PRs sharing any of servers, with the shared servers
+func overlappingPRs(key string, servers map[string]bool) map[string][]string {
+    lastImpacted.Lock()
+    defer lastImpacted.Unlock()
+    overlaps := make(map[string][]string)
+    for other, otherServers := range lastImpacted.m {
+        if other == key {
+            continue
+        }
+        for _, s := range sortedKeys(servers) {
+            if otherServers[s] {
+                overlaps[other] = append(overlaps[other], s)
+            }
+        }
+    }
+    return overlaps
+}
+
+// impactDelta returns the servers in cur but not prev, and those in prev but not cur
+func impactDelta(prev, cur map[string]bool) (added, removed []string) {
+    for _, s := range sortedKeys(cur) {
+        if !prev[s] {
+            added = append(added, s)
+        }
+    }
+    for _, s := range sortedKeys(prev) {
+        if !cur[s] {
+            removed = append(removed, s)
+        }
+    }
+    return added, removed
+}
+
+// reportImpactDelta comments on a push with how the PR's impacted servers changed since
+// prev, the set from the previous validation. Nothing is posted without a previous set or
+// when the set is unchanged.
+func reportImpactDelta(ctx context.Context, pc *prContext, prev map[string]bool, ok bool) error {
+    if !ok || pc.Action != "synchronize" {
+        return nil
+    }
+    added, removed := impactDelta(prev, pc.ImpactedServers)
+    if len(added) == 0 && len(removed) == 0 {
+        return nil
+    }
+    var b strings.Builder
+    head := ""
+    if pc.Details != nil {
+        head = " (" + pc.Details.Head.SHA + ")"
+    }
+    fmt.Fprintf(&b, "**Impacted servers changed by the latest push%s**\n\n", head)
+    for _, s := range added {
+        fmt.Fprintf(&b, "- added: `%s`\n", s)
+    }
+    for _, s := range removed {
+        fmt.Fprintf(&b, "- removed: `%s`\n", s)
+    }
+    fmt.Fprintf(&b, "\n%d server(s) impacted in total.\n", len(pc.ImpactedServers))
+    fmt.Fprintf(&b, "\n<sub>Report ID: %s</sub>\n", pc.ReportID)
+    return githubAPI.UpsertComment(ctx, pc.Owner, pc.Repo, pc.Number, impactDeltaMarker, b.String())
+}
+
+// impactReport renders the validation outcome and each changed app's impacted servers as
+// markdown, or "" when the PR changes no apps
+func impactReport(ctx context.Context, pc *prContext, outcome string) string {
+    apps := make(map[string]bool)
+    for _, app := range pc.ChangedApps {
+        apps[app] = true
+    }
+    for app := range pc.AppServers {
+        apps[app] = true
+    }
+    if len(apps) == 0 {
+        return ""
+    }
+    appsJson, err := headAppsJson(ctx, pc)
+    if err != nil {
+        loggerFrom(ctx).Printf("Could not read apps.json for the impact report: %v", err)
+    }
+    var b strings.Builder
+    b.WriteString("### commitvalidator\n\n")
+    if outcome != "" {
+        b.WriteString(outcome + "\n\n")
+    }
+    b.WriteString("| App | Impacted servers | Added | Removed |\n| --- | --- | --- | --- |\n")
+    for _, app := range sortedKeys(apps) {
+        servers, ok := pc.AppServers[app]
+        if !ok && appsJson != nil {
+            for _, a := range appsJson.Apps {
+                if a.Name == app {
+                    servers, _, err = computeImpactedServers(ctx, a)
+                    if err != nil {
+                        loggerFrom(ctx).Printf("Could not compute impacted servers for %s: %v", app, err)
+                    }
+                    break
+                }
+            }
+        }
+        list := "_unknown_"
+        if servers != nil {
+            list = "none"
+            if len(servers) > 0 {
+                list = strings.Join(sortedKeys(servers), ", ")
+            }
+        }
+        // Added and removed servers are only known for apps whose apps.json entry changed
+        addedList, removedList := "", ""
+        if base, ok := pc.BaseAppServers[app]; ok {
+            added, removed := impactDelta(base, servers)
+            addedList, removedList = strings.Join(added, ", "), strings.Join(removed, ", ")
+        }
+        fmt.Fprintf(&b, "| %s | %s | %s | %s |\n", app, list, addedList, removedList)
+    }
+    fmt.Fprintf(&b, "\n<sub>Report ID: %s</sub>\n", pc.ReportID)
+    return b.String()
+}
+
+// postTrackingIssueComments adds or updates, on each changed app's app_tracking_issues issue,
+// a comment linking the PR with the servers it adds to and removes from the app's impact
+func postTrackingIssueComments(ctx context.Context, pc *prContext) error {
+    apps := make(map[string]bool)
+    for _, app := range pc.ChangedApps {
+        apps[app] = true
+    }
+    for app := range pc.AppServers {
+        apps[app] = true
+    }
+    baseApps := make(map[string]App)
+    if pc.BaseAppsJson != nil {
+        for _, app := range pc.BaseAppsJson.Apps {
+            baseApps[app.Name] = app
+        }
+    }
+    for _, app := range sortedKeys(apps) {
+        issue, ok := config.AppTrackingIssues[app]
+        if !ok {
+            continue
+        }
+        var b strings.Builder
+        fmt.Fprintf(&b, "**%s/%s#%d** changes %s", pc.Owner, pc.Repo, pc.Number, app)
+        if pc.Details != nil {
+            fmt.Fprintf(&b, " (%s)", pc.Details.Head.SHA)
+        }
+        b.WriteString("\n\n")
+        if cur, ok := pc.AppServers[app]; ok {
+            prev := make(map[string]bool)
+            if baseApp, ok := baseApps[app]; ok {
+                var err error
+                if prev, _, err = computeImpactedServers(ctx, baseApp); err != nil {
+                    return err
+                }
+            }
+            added, removed := impactDelta(prev, cur)
+            for _, s := range added {
+                fmt.Fprintf(&b, "- added: `%s`\n", s)
+            }
+            for _, s := range removed {
+                fmt.Fprintf(&b, "- removed: `%s`\n", s)
+            }
+            if len(added) == 0 && len(removed) == 0 {
+                b.WriteString("Impacted servers unchanged.\n")
+            }
+        } else {
+            b.WriteString("apps.json is unchanged, impacted servers are the same.\n")
+        }
+        fmt.Fprintf(&b, "\n<sub>Report ID: %s</sub>\n", pc.ReportID)
+        marker := fmt.Sprintf("<!-- commitvalidator:pr-%s/%s#%d -->", pc.Owner, pc.Repo, pc.Number)
+        if err := githubAPI.UpsertComment(ctx, pc.Owner, pc.Repo, issue, marker, b.String()); err != nil {
+            return fmt.Errorf("commenting on tracking issue #%d for %s: %v", issue, app, err)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "io/ioutil"
+    "log"
+    "os"
+    "sync"
+    "time"
+)
+
+// fileInventory is a CMDBResolver backed by a local inventory export of the form
+// {"<key>": {"<value>": ["host1", ...]}}, e.g. {"group": {"payments-prod": [...]}}.
+// The file is re-read when it changes; a bad file keeps the previous data.
+type fileInventory struct {
+    path string
+
+    mu     sync.RWMutex
+    groups map[string]map[string][]string
+}
+
+// newFileInventory loads the inventory at path and starts watching it for changes
+func newFileInventory(path string, pollInterval, debounce time.Duration) (*fileInventory, error) {
+    inv := &fileInventory{path: path}
+    if err := inv.reload(); err != nil {
+        return nil, err
+    }
+    go inv.watch(pollInterval, debounce)
+    return inv, nil
+}
+
+// ResolveGroup returns the servers listed for key=value, or errUnknownCMDBGroup when the inventory lacks them
+func (inv *fileInventory) ResolveGroup(ctx context.Context, key, value string) ([]string, error) {
+    inv.mu.RLock()
+    defer inv.mu.RUnlock()
+    servers, ok := inv.groups[key][value]
+    if !ok {
+        return nil, errUnknownCMDBGroup
+    }
+    return servers, nil
+}
+
+// reload parses the inventory file and swaps it in
+func (inv *fileInventory) reload() error {
+    data, err := ioutil.ReadFile(inv.path)
+    if err != nil {
+        return err
+    }
+    var groups map[string]map[string][]string
+    if err := json.Unmarshal(data, &groups); err != nil {
+        return err
+    }
+    inv.mu.Lock()
+    inv.groups = groups
+    inv.mu.Unlock()
+    return nil
+}
+
+// watch polls the file's modification time and reloads once it has stopped
+// changing for the debounce period, so half-written exports aren't picked up
+func (inv *fileInventory) watch(pollInterval, debounce time.Duration) {
+    var loaded, pending time.Time
+    if fi, err := os.Stat(inv.path); err == nil {
+        loaded = fi.ModTime()
+    }
+    var changedAt time.Time
+    for range time.Tick(pollInterval) {
+        fi, err := os.Stat(inv.path)
+        if err != nil {
+            log.Printf("Could not stat inventory %s: %v", inv.path, err)
+            continue
+        }
+        mod := fi.ModTime()
+        if mod.Equal(loaded) {
+            continue
+        }
+        if !mod.Equal(pending) {
+            pending, changedAt = mod, time.Now()
+            continue
+        }
+        if time.Since(changedAt) < debounce {
+            continue
+        }
+        if err := inv.reload(); err != nil {
+            log.Printf("Could not reload inventory %s, keeping previous data: %v", inv.path, err)
+        } else {
+            log.Printf("Reloaded inventory %s", inv.path)
+        }
+        loaded = mod
+    }
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "log/slog"
+    "os"
+    "strings"
+)
+
+// logLevel is the minimum level logged; LOG_LEVEL=debug lowers it to include debugf lines
+var logLevel slog.LevelVar
+
+// debugf logs at debug level, with the fields of the delivery logger ctx carries
+func debugf(ctx context.Context, format string, args ...interface{}) {
+    h := slog.Default().Handler()
+    if d, ok := ctx.Value(deliveryKey{}).(deliveryLog); ok && d.handler != nil {
+        h = d.handler
+    }
+    if h.Enabled(ctx, slog.LevelDebug) {
+        slog.New(h).Log(ctx, slog.LevelDebug, fmt.Sprintf(format, args...))
+    }
+}
+
+// setupLogging sends all logging, including the standard logger, through slog as JSON, or as
+// key=value text when format is "text" (LOG_FORMAT)
+func setupLogging(format string) {
+    opts := &slog.HandlerOptions{Level: &logLevel}
+    var h slog.Handler
+    if strings.EqualFold(format, "text") {
+        h = slog.NewTextHandler(os.Stderr, opts)
+    } else {
+        h = slog.NewJSONHandler(os.Stderr, opts)
+    }
+    slog.SetDefault(slog.New(h))
+}
+
+// deliveryKey is the context key a deliveryLog is stored under
+type deliveryKey struct{}
+
+// deliveryLog is the logger of one delivery, and the slog handler behind it for debugf
+type deliveryLog struct {
+    lg      *log.Logger
+    handler slog.Handler
+}
+
+// withLogger returns a copy of ctx carrying lg, so the GitHub client and other code below
+// the handler log through it
+func withLogger(ctx context.Context, lg *log.Logger) context.Context {
+    return context.WithValue(ctx, deliveryKey{}, deliveryLog{lg: lg})
+}
+
+// loggerFrom returns the logger ctx carries, else the standard logger
+func loggerFrom(ctx context.Context) *log.Logger {
+    if d, ok := ctx.Value(deliveryKey{}).(deliveryLog); ok {
+        return d.lg
+    }
+    return log.Default()
+}
+
+// deliveryLogger returns a logger for one webhook delivery whose lines carry attrs as fields,
+// and a copy of ctx carrying it for debugf and the code below the handler
+func deliveryLogger(ctx context.Context, attrs ...slog.Attr) (context.Context, *log.Logger) {
+    h := slog.Default().Handler().WithAttrs(attrs)
+    lg := slog.NewLogLogger(h, slog.LevelInfo)
+    return context.WithValue(ctx, deliveryKey{}, deliveryLog{lg: lg, handler: h}), lg
+}
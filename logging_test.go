@@ -0,0 +1,32 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "log/slog"
+    "strings"
+    "testing"
+)
+
+func TestDebugfLogsAtDebugLevelWithDeliveryFields(t *testing.T) {
+    var buf bytes.Buffer
+    var level slog.LevelVar
+    saved := slog.Default()
+    slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: &level})))
+    defer slog.SetDefault(saved)
+
+    ctx, _ := deliveryLogger(context.Background(), slog.String("report_id", "abc123"))
+    debugf(ctx, "hidden at info")
+    if buf.Len() != 0 {
+        t.Errorf("debug line logged at info level: %s", buf.String())
+    }
+
+    level.Set(slog.LevelDebug)
+    debugf(ctx, "event %s", "opened")
+    line := buf.String()
+    for _, want := range []string{`"level":"DEBUG"`, `"msg":"event opened"`, `"report_id":"abc123"`} {
+        if !strings.Contains(line, want) {
+            t.Errorf("debug line %s is missing %s", line, want)
+        }
+    }
+}
@@ -2,61 +2,96 @@
 package main
 
 import (
+    "context"
     "encoding/json"
+    "errors"
+    "io"
     "fmt"
     "io/ioutil"
     "log"
+    "log/slog"
+    "net"
     "net/http"
+    "net/url"
     "os"
-    "bytes"
+    "path"
+    "sort"
+    "strings"
+    "sync"
+    "time"
 )
 
-// App represents an app config in apps.json
-type App struct {
-    Name            string   `json:"name"`
-    CMDBWhitelists  []map[string]string `json:"cmdb_whitelists"`
-    CMDBBlacklists  []map[string]string `json:"cmdb_blacklists"`
-    Whitelists      []string `json:"whitelists"`
-    Blacklists      []string `json:"blacklists"`
-}
+func prWebhookHandler(w http.ResponseWriter, r *http.Request) {
+    body, err := ioutil.ReadAll(r.Body)
+    if err != nil {
+        http.Error(w, "Could not read request body", http.StatusInternalServerError)
+        return
+    }
+    // The signature covers the raw body, so check it before anything is parsed
+    if webhookSecret != "" && !validSignature(webhookSecret, body, r.Header.Get("X-Hub-Signature-256")) {
+        log.Printf("Rejected webhook delivery %s with a missing or invalid signature", r.Header.Get("X-GitHub-Delivery"))
+        http.Error(w, "Invalid signature", http.StatusUnauthorized)
+        return
+    }
 
-// AppsJson represents the structure of apps.json
-type AppsJson struct {
-    Apps []App `json:"apps"`
-}
+    // GitHub redelivers on timeouts; handle each delivery once. The ID is claimed up front so
+    // concurrent redeliveries are dropped, and released unless the PR was handled through to a
+    // result, so a redelivery after a failure (say, GitHub erroring on the files) is processed.
+    deliveryID := r.Header.Get("X-GitHub-Delivery")
+    done := false
+    if deliveryID != "" {
+        if deliveries.Seen(deliveryID) {
+            log.Printf("Ignoring duplicate webhook delivery %s", deliveryID)
+            fmt.Fprintf(w, "Duplicate delivery %s, ignoring", deliveryID)
+            return
+        }
+        defer func() {
+            if !done {
+                deliveries.Forget(deliveryID)
+            }
+        }()
+    }
 
-// Helper to compare two App configs
-func appConfigEqual(a, b App) bool {
-    aBytes, _ := json.Marshal(a)
-    bBytes, _ := json.Marshal(b)
-    return bytes.Equal(aBytes, bBytes)
-}
-func prWebhookHandler(w http.ResponseWriter, r *http.Request) {
-    var payload []byte
+    // Only pull request and review deliveries carry a PR; answer everything else without parsing it
+    switch event := r.Header.Get("X-GitHub-Event"); event {
+    case "pull_request", "pull_request_review":
+    case "ping":
+        webhooksReceived.Inc(event, "")
+        fmt.Fprint(w, "pong")
+        return
+    default:
+        webhooksReceived.Inc(event, "")
+        debugf(r.Context(), "Ignoring %q webhook delivery %s", event, r.Header.Get("X-GitHub-Delivery"))
+        fmt.Fprintf(w, "ignored event type %s", event)
+        return
+    }
+
+    payload := body
     if r.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
         // Parse form and get the payload field
-        if err := r.ParseForm(); err != nil {
-            http.Error(w, "Could not parse form", http.StatusBadRequest)
-            return
-        }
-        payloadStr := r.FormValue("payload")
-        payload = []byte(payloadStr)
-    } else {
-        // Assume JSON
-        var err error
-        payload, err = ioutil.ReadAll(r.Body)
+        form, err := url.ParseQuery(string(body))
         if err != nil {
-            http.Error(w, "Could not read request body", http.StatusInternalServerError)
+            http.Error(w, "Could not parse form", http.StatusBadRequest)
             return
         }
+        payload = []byte(form.Get("payload"))
     }
 
+    saveDebugPayload(r.Header.Get("X-GitHub-Delivery"), payload)
+
+    rep := &webhookReport{}
+    defer rep.send(w, r)
+
     // Parse the webhook payload
     var prEvent struct {
         Action string `json:"action"`
         Number int    `json:"number"`
         PullRequest struct {
-            Number int `json:"number"`
+            Number int     `json:"number"`
+            Labels []Label `json:"labels"`
+            Head   struct {
+                SHA string `json:"sha"`
+            } `json:"head"`
         } `json:"pull_request"`
         Repository struct {
             Name  string `json:"name"`
@@ -68,14 +103,32 @@ func prWebhookHandler(w http.ResponseWriter, r *http.Request) {
     if err := json.Unmarshal(payload, &prEvent); err != nil {
         log.Printf("Could not parse PR event: %v", err)
         log.Printf("Raw payload: %s", string(payload))
-        fmt.Fprintf(w, "Webhook received, but could not parse PR event")
+        fmt.Fprintf(rep, "Webhook received, but could not parse PR event")
         return
     }
 
-    // Only handle PR events with action 'opened' or 'reopened'
-    if prEvent.Action != "opened" && prEvent.Action != "reopened" {
-        log.Printf("Ignoring PR event with action: %s", prEvent.Action)
-        fmt.Fprintf(w, "Ignoring PR event with action: %s", prEvent.Action)
+    webhooksReceived.Inc(r.Header.Get("X-GitHub-Event"), prEvent.Action)
+
+    // The report ID ties this run's logs, statuses, comments and analytics together
+    reportID := newReportID(deliveryID, prEvent.PullRequest.Head.SHA)
+    // The GitHub client logs through lg as well. Results are posted even if GitHub stops
+    // waiting for the response.
+    ctx, lg := deliveryLogger(context.WithoutCancel(r.Context()), slog.String("report_id", reportID), slog.String("delivery_id", deliveryID))
+    rep.ReportID = reportID
+
+    // Only handle PR events with action 'opened', 'reopened' or 'synchronize' (new commits
+    // pushed), and submitted or dismissed reviews so approval requirements are re-evaluated
+    reviewEvent := r.Header.Get("X-GitHub-Event") == "pull_request_review" && (prEvent.Action == "submitted" || prEvent.Action == "dismissed")
+    // Closed PRs no longer compete for servers
+    if prEvent.Action == "closed" && r.Header.Get("X-GitHub-Event") == "pull_request" {
+        forgetImpacted(prKey(prEvent.Repository.Owner.Login, prEvent.Repository.Name, prEvent.PullRequest.Number))
+        setAdminReopened(prEvent.Repository.Owner.Login, prEvent.Repository.Name, prEvent.PullRequest.Number, false)
+    }
+    handled := prEvent.Action == "opened" || prEvent.Action == "reopened" || prEvent.Action == "synchronize" || reviewEvent
+    debugf(ctx, "Received %s event with action %q, handled: %t", r.Header.Get("X-GitHub-Event"), prEvent.Action, handled)
+    if !handled {
+        debugf(ctx, "Ignoring PR event with action: %s", prEvent.Action)
+        fmt.Fprintf(rep, "Ignoring PR event with action: %s", prEvent.Action)
         return
     }
 
@@ -84,27 +137,206 @@ func prWebhookHandler(w http.ResponseWriter, r *http.Request) {
         prNumber = prEvent.Number
     }
     if prNumber == 0 {
-        log.Printf("No PR number found in event")
-        fmt.Fprintf(w, "No PR number found")
+        lg.Printf("No PR number found in event")
+        fmt.Fprintf(rep, "No PR number found")
         return
     }
 
     owner := prEvent.Repository.Owner.Login
     repo := prEvent.Repository.Name
-    log.Printf("PR #%d opened for repo %s/%s", prNumber, owner, repo)
+    ctx, lg = deliveryLogger(context.WithoutCancel(r.Context()), slog.String("report_id", reportID), slog.String("delivery_id", deliveryID),
+        slog.String("owner", owner), slog.String("repo", repo), slog.Int("pr_number", prNumber))
+    recordRepo(owner, repo)
+    lg.Printf("PR #%d opened for repo %s/%s", prNumber, owner, repo)
+    // An admin reopen only spares the PR its "reopened" validation, not later pushes
+    if prEvent.Action == "synchronize" {
+        setAdminReopened(owner, repo, prNumber, false)
+    }
+
+    // With require-new-commit, reopening a failed PR waits for a push instead of re-validating
+    if prEvent.Action == "reopened" && config.ReopenAction == "require-new-commit" && validations.LastStatus(owner+"/"+repo, prNumber) == "failure" {
+        lg.Printf("PR #%d reopened after failing validation, waiting for new commits", prNumber)
+        if err := githubAPI.PostComment(ctx, owner, repo, prNumber, reopenNeedsCommit); err != nil {
+            lg.Printf("Error posting reopen comment: %v", err)
+        }
+        fmt.Fprintf(rep, "PR #%d reopened after failing validation, waiting for new commits\n", prNumber)
+        done = true
+        return
+    }
 
     // Fetch changed files from GitHub API
-    files, err := fetchPRFiles(owner, repo, prNumber)
+    files, err := githubAPI.FetchPRFiles(ctx, owner, repo, prNumber)
     if err != nil {
-        log.Printf("Error fetching PR files: %v", err)
-        fmt.Fprintf(w, "Error fetching PR files")
+        lg.Printf("Error fetching PR files: %v", err)
+        fmt.Fprintf(rep, "Error fetching PR files")
+        return
+    }
+    lg.Printf("Changed files in PR #%d:", prNumber)
+    for _, f := range files {
+        lg.Printf("- %s (additions: %d, deletions: %d, changes: %d)", f.Filename, f.Additions, f.Deletions, f.Changes)
+    }
+
+    // An empty PR has nothing to validate; say so rather than passing it silently
+    if len(files) == 0 {
+        state, description := "neutral", "No files changed, nothing to validate."
+        if config.EmptyPRAction == "fail" {
+            state, description = "failure", "No files changed."
+        }
+        lg.Printf("PR #%d has no changed files", prNumber)
+        if err := githubAPI.UpdateStatus(ctx, owner, repo, prNumber, state, description, reportURL(reportID)); err != nil {
+            lg.Printf("Error updating PR status: %v", err)
+        }
+        rep.PR, rep.Status, rep.Description = prNumber, state, description
+        fmt.Fprintf(rep, "PR #%d has no changed files. Status: %s\n", prNumber, state)
+        done = true
         return
     }
-    log.Printf("Changed files in PR #%d:", prNumber)
+
+    details, err := githubAPI.FetchPRDetails(ctx, owner, repo, prNumber)
+    if err != nil {
+        lg.Printf("Error fetching PR details: %v", err)
+    }
+    // Authors outside the org don't trigger any validation when non_member_action is skip
+    if config.NonMemberAction == "skip" && details != nil {
+        member, err := githubAPI.IsOrgMember(ctx, owner, details.User.Login)
+        if errors.Is(err, errNotOrg) {
+            debugf(ctx, "%s is not an organization, validating PR #%d from %s", owner, prNumber, details.User.Login)
+        } else if err != nil {
+            lg.Printf("Error checking org membership of %s: %v", details.User.Login, err)
+        } else if !member {
+            lg.Printf("Skipping PR #%d from %s, who is not a member of %s", prNumber, details.User.Login, owner)
+            fmt.Fprintf(rep, "Skipping PR #%d from non-member %s\n", prNumber, details.User.Login)
+            done = true
+            return
+        }
+    }
+    pc := &prContext{
+        Owner:           owner,
+        Repo:            repo,
+        Number:          prNumber,
+        Action:          prEvent.Action,
+        Labels:          prEvent.PullRequest.Labels,
+        Files:           files,
+        ReportID:        reportID,
+        Details:         details,
+        AppServers:      make(map[string]map[string]bool),
+        BaseAppServers:  make(map[string]map[string]bool),
+        ImpactedServers: make(map[string]bool),
+        ProdServers:     make(map[string]bool),
+    }
+
+    v := validateChanges(ctx, pc, rep, lg)
+    status, description, comment := v.Status, v.Description, v.Comment
+    results, profile := v.Results, v.Profile
+    violations, warnings, pending := v.Violations, v.Warnings, v.Pending
+
+    // Update PR status on GitHub (do not close PR if failed)
+    if useChecksAPI && details != nil {
+        err = githubAPI.PostCheckRun(ctx, owner, repo, details.Head.SHA, status, description, impactSummary(pc.AppServers), failureAnnotations(files, results, v.FileViolations))
+    } else {
+        err = githubAPI.UpdateStatus(ctx, owner, repo, prNumber, status, description, reportURL(reportID))
+    }
+    if err != nil {
+        lg.Printf("Error updating PR status: %v", err)
+        // Without a status the PR shows no result at all, so say it in a comment instead
+        if config.StatusFallbackComment {
+            body := fmt.Sprintf("%s\nThe validation status could not be posted. Result: **%s**: %s", statusFallbackMarker, status, description)
+            if url := reportURL(reportID); url != "" {
+                body += fmt.Sprintf("\n\n[Full report](%s)", url)
+            }
+            if err := githubAPI.UpsertComment(ctx, owner, repo, prNumber, statusFallbackMarker, body); err != nil {
+                lg.Printf("Error posting status fallback comment: %v", err)
+            }
+        }
+    }
+    // Failing PRs from always_close_authors are closed regardless of other settings;
+    // otherwise FAILURE_ACTION decides what happens beyond the failing status
+    if status == "failure" && details != nil && containsFold(config.AlwaysCloseAuthors, details.User.Login) {
+        lg.Printf("PR #%d author %s is in always_close_authors, closing it", prNumber, details.User.Login)
+        if err := closeFailedPR(ctx, owner, repo, prNumber, prEvent.Action); err != nil {
+            lg.Printf("Error closing PR: %v", err)
+        }
+    } else if status == "failure" {
+        switch failureAction {
+        case "close":
+            if err := closeFailedPR(ctx, owner, repo, prNumber, prEvent.Action); err != nil {
+                lg.Printf("Error closing PR: %v", err)
+            }
+        case "comment":
+            if err := githubAPI.PostReviewComment(ctx, owner, repo, prNumber, comment); err != nil {
+                lg.Printf("Error posting review comment: %v", err)
+            }
+        }
+    }
+    emitValidationEvent(owner, repo, prNumber, reportID, profile, status, results)
+    recordValidation(pc, v)
+
+    // Route failures to triage queues via the labels configured for the failing rules
+    if labels := failureLabels(results); len(labels) > 0 {
+        lg.Printf("Applying failure labels to PR #%d: %v", prNumber, labels)
+        if err := githubAPI.AddLabels(ctx, owner, repo, prNumber, labels); err != nil {
+            lg.Printf("Error applying failure labels: %v", err)
+        }
+    }
+    // Sensitive paths always get a status of their own, whichever rules ran
+    if details != nil {
+        if err := postMandatoryPathsStatus(ctx, owner, repo, details.Head.SHA, status, files); err != nil {
+            lg.Printf("Error posting mandatory paths status: %v", err)
+        }
+    }
+    // Track the impacted servers of open PRs for delta comments and the overlapping-prs rule
+    prevImpact, hadPrevImpact := swapImpacted(prKey(owner, repo, prNumber), pc.ImpactedServers)
+    if config.ImpactDeltaComments {
+        if err := reportImpactDelta(ctx, pc, prevImpact, hadPrevImpact); err != nil {
+            lg.Printf("Error commenting impacted server delta: %v", err)
+        }
+    }
+    // The rollup goes last so it reflects every sub-check
+    if config.RollupStatus && details != nil {
+        if err := postRollupStatus(ctx, owner, repo, details.Head.SHA, status, results); err != nil {
+            lg.Printf("Error posting rollup status: %v", err)
+        }
+    }
+    if len(config.AppTrackingIssues) > 0 {
+        if err := postTrackingIssueComments(ctx, pc); err != nil {
+            lg.Printf("Error updating tracking issues: %v", err)
+        }
+    }
+    // Reviewers see the result and impacted servers in a single comment, updated on each validation
+    if comment != "" {
+        lg.Printf("PR #%d comment: %s", prNumber, comment)
+    }
+    if summary := impactReport(ctx, pc, comment); summary != "" {
+        if err := githubAPI.UpsertComment(ctx, owner, repo, prNumber, reportMarker, summary); err != nil {
+            lg.Printf("Error posting impacted servers comment: %v", err)
+        }
+    }
+    done = true
+    rep.PR, rep.Status, rep.Description = prNumber, status, description
+    rep.Violations, rep.Warnings, rep.Pending = violations, warnings, pending
+    fmt.Fprintf(rep, "PR #%d validation complete. Status: %s\n", prNumber, status)
+    fmt.Fprintf(rep, "Files changed in PR:\n")
     for _, f := range files {
-        log.Printf("- %s (additions: %d, deletions: %d, changes: %d)", f.Filename, f.Additions, f.Deletions, f.Changes)
+        fmt.Fprintf(rep, "- %s (additions: %d, deletions: %d, changes: %d)\n", f.Filename, f.Additions, f.Deletions, f.Changes)
     }
+}
+
+// validation is the outcome of validating a PR's changes
+type validation struct {
+    Status         string
+    Description    string
+    Comment        string
+    Profile        string
+    Results        []ruleResult
+    FileViolations []string
+    Violations     []string
+    Warnings       []string
+    Pending        []string
+}
 
+// validateChanges reports the PR's changed apps and impacted servers to rep and lg, then
+// runs the rules and file checks. Both the GitHub and GitLab webhooks validate through it.
+func validateChanges(ctx context.Context, pc *prContext, rep io.Writer, lg *log.Logger) validation {
         // --- Enhanced Reporting ---
         // Generic detection of changed apps, modules, and files
         type ChangedFile struct {
@@ -114,89 +346,101 @@ func prWebhookHandler(w http.ResponseWriter, r *http.Request) {
             PRFile     PRFile
         }
         var changedFiles []ChangedFile
+        var violations []string
+        var warnings []string
+        var pending []string
         var changedAppsMap = make(map[string]bool)
         var appsJsonPatch string
-        for _, f := range files {
+        var ignoredFiles []string
+        for _, f := range pc.Files {
             // Detect apps.json diff
             if f.Filename == "apps.json" {
                 appsJsonPatch = f.Patch
                 continue
             }
-            // Expect structure: appname/moduleName/filename
-            parts := bytes.Split([]byte(f.Filename), []byte("/"))
-            if len(parts) >= 3 {
-                appName := string(parts[0])
-                moduleName := string(parts[1])
-                fileName := string(parts[2])
-                changedFiles = append(changedFiles, ChangedFile{
-                    AppName: appName,
-                    ModuleName: moduleName,
-                    FileName: fileName,
-                    PRFile: f,
-                })
-                changedAppsMap[appName] = true
+            // Expect structure: appname/moduleName/path/to/filename
+            appName, moduleName, fileName, ok := splitAppPath(f.Filename)
+            if !ok {
+                ignoredFiles = append(ignoredFiles, f.Filename)
+                continue
             }
+            changedFiles = append(changedFiles, ChangedFile{
+                AppName: appName,
+                ModuleName: moduleName,
+                FileName: fileName,
+                PRFile: f,
+            })
+            changedAppsMap[appName] = true
+        }
+        // Files outside any app module (repo-level or app-level files) impact no servers
+        if len(ignoredFiles) > 0 {
+            lg.Printf("Ignored %d files outside app/module directories: %s", len(ignoredFiles), strings.Join(ignoredFiles, ", "))
+            fmt.Fprintf(rep, "Ignored %d files outside app/module directories\n", len(ignoredFiles))
         }
         var changedApps []string
         for app := range changedAppsMap {
             changedApps = append(changedApps, app)
         }
+        sort.Strings(changedApps)
+        pc.ChangedApps = changedApps
         if len(changedApps) > 0 {
-            log.Printf("Apps changed in PR: %v", changedApps)
-            fmt.Fprintf(w, "Apps changed in PR: %v\n", changedApps)
-            log.Printf("Changed modules and files:")
-            fmt.Fprintf(w, "Changed modules and files:\n")
+            lg.Printf("Apps changed in PR: %v", changedApps)
+            fmt.Fprintf(rep, "Apps changed in PR: %v\n", changedApps)
+            lg.Printf("Changed modules and files:")
+            fmt.Fprintf(rep, "Changed modules and files:\n")
             for _, cf := range changedFiles {
-                log.Printf("- %s/%s/%s (additions: %d, deletions: %d, changes: %d)", cf.AppName, cf.ModuleName, cf.FileName, cf.PRFile.Additions, cf.PRFile.Deletions, cf.PRFile.Changes)
-                fmt.Fprintf(w, "- %s/%s/%s (additions: %d, deletions: %d, changes: %d)\n", cf.AppName, cf.ModuleName, cf.FileName, cf.PRFile.Additions, cf.PRFile.Deletions, cf.PRFile.Changes)
+                lg.Printf("- %s/%s/%s (additions: %d, deletions: %d, changes: %d)", cf.AppName, cf.ModuleName, cf.FileName, cf.PRFile.Additions, cf.PRFile.Deletions, cf.PRFile.Changes)
+                fmt.Fprintf(rep, "- %s/%s/%s (additions: %d, deletions: %d, changes: %d)\n", cf.AppName, cf.ModuleName, cf.FileName, cf.PRFile.Additions, cf.PRFile.Deletions, cf.PRFile.Changes)
             }
         }
         if appsJsonPatch != "" {
-            log.Printf("apps.json changes:\n%s", appsJsonPatch)
-            fmt.Fprintf(w, "apps.json changes:\n%s\n", appsJsonPatch)
+            lg.Printf("apps.json changes:\n%s", appsJsonPatch)
+            fmt.Fprintf(rep, "apps.json changes:\n%s\n", appsJsonPatch)
 
             var prAppsJson, mainAppsJson AppsJson
 
-            // Helper to fetch file from GitHub API
-            fetchFileFromBranch := func(owner, repo, path, ref, token string) ([]byte, error) {
-                url := fmt.Sprintf("https://api.github.com/repos/%s/%s/contents/%s?ref=%s", owner, repo, path, ref)
-                req, err := http.NewRequest("GET", url, nil)
-                if err != nil {
-                    return nil, err
-                }
-                if token != "" {
-                    req.Header.Set("Authorization", "token "+token)
-                }
-                req.Header.Set("Accept", "application/vnd.github.v3.raw")
-                client := &http.Client{}
-                resp, err := client.Do(req)
-                if err != nil {
-                    return nil, err
+            // Read the proposed apps.json at the PR's head SHA so impact reflects the actual change
+            prRef := pc.HeadRef()
+            // Compare against the PR's base commit, or main when the PR details are unavailable
+            mainBranch := "main"
+            if pc.Details != nil && pc.Details.Base.SHA != "" {
+                mainBranch = pc.Details.Base.SHA
+            }
+
+            prAppsBytes, err := pc.FileContent(ctx, "apps.json", prRef)
+            if err == nil {
+                lg.Printf("Using apps.json from PR head %s", prRef)
+                // Malformed fields would otherwise be zeroed by json.Unmarshal and pass unnoticed
+                for _, msg := range validateAppsJsonSchema(prAppsBytes) {
+                    lg.Printf("  Violation: %s", msg)
+                    fmt.Fprintf(rep, "  Violation: %s\n", msg)
+                    violations = append(violations, msg)
                 }
-                defer resp.Body.Close()
-                if resp.StatusCode != 200 {
-                    body, _ := ioutil.ReadAll(resp.Body)
-                    return nil, fmt.Errorf("GitHub API error: %s", string(body))
+            } else {
+                lg.Printf("Error fetching apps.json at PR head %s, falling back to %s: %v", prRef, appsJsonPath, err)
+                prAppsBytes, err = ioutil.ReadFile(appsJsonPath)
+                if err == nil {
+                    lg.Printf("Using apps.json from disk at %s", appsJsonPath)
+                } else {
+                    lg.Printf("Could not read apps.json at %s: %v", appsJsonPath, err)
                 }
-                return ioutil.ReadAll(resp.Body)
             }
-
-            token := os.Getenv("GITHUB_TOKEN")
-            // prRef removed (was unused)
-            prBranch := fmt.Sprintf("refs/pull/%d/head", prNumber)
-            mainBranch := "main"
-
-            prAppsBytes, err := fetchFileFromBranch(owner, repo, "apps.json", prBranch, token)
             if err == nil {
                 json.Unmarshal(prAppsBytes, &prAppsJson)
-            } else {
-                log.Printf("Error fetching apps.json from PR branch: %v", err)
+                pc.PRAppsJson = &prAppsJson
+            }
+            mainAppsBytes, err := pc.FileContent(ctx, "apps.json", mainBranch)
+            if err != nil {
+                // The deployed copy at APPS_JSON_PATH stands in for main when GitHub can't provide it
+                lg.Printf("Error fetching apps.json at base %s, reading %s instead: %v", mainBranch, appsJsonPath, err)
+                mainAppsBytes, err = ioutil.ReadFile(appsJsonPath)
+                if err != nil {
+                    lg.Printf("Warning: could not read apps.json at %s (set APPS_JSON_PATH), skipping impacted servers: %v", appsJsonPath, err)
+                }
             }
-            mainAppsBytes, err := fetchFileFromBranch(owner, repo, "apps.json", mainBranch, token)
             if err == nil {
                 json.Unmarshal(mainAppsBytes, &mainAppsJson)
-            } else {
-                log.Printf("Error fetching apps.json from main branch: %v", err)
+                pc.BaseAppsJson = &mainAppsJson
             }
 
             // Only report apps with config changes
@@ -221,260 +465,551 @@ func prWebhookHandler(w http.ResponseWriter, r *http.Request) {
                     })
                 }
             }
-            if len(impactedApps) == 0 {
-                log.Printf("No apps impacted by apps.json changes.")
-                fmt.Fprintf(w, "No apps impacted by apps.json changes.\n")
+            // Report apps in name order so repeated runs produce identical output
+            sort.Slice(impactedApps, func(i, j int) bool { return impactedApps[i].Name < impactedApps[j].Name })
+            if pc.BaseAppsJson == nil {
+                fmt.Fprintf(rep, "Skipping impacted servers: no base apps.json to compare against.\n")
+            } else if len(impactedApps) == 0 {
+                lg.Printf("No apps impacted by apps.json changes.")
+                fmt.Fprintf(rep, "No apps impacted by apps.json changes.\n")
             } else {
-                log.Printf("Apps impacted by apps.json changes:")
-                fmt.Fprintf(w, "Apps impacted by apps.json changes:\n")
+                lg.Printf("Apps impacted by apps.json changes:")
+                fmt.Fprintf(rep, "Apps impacted by apps.json changes:\n")
                 for _, diff := range impactedApps {
-                    log.Printf("- %s", diff.Name)
-                    fmt.Fprintf(w, "- %s\n", diff.Name)
+                    lg.Printf("- %s", diff.Name)
+                    fmt.Fprintf(rep, "- %s\n", diff.Name)
                     // Print impacted servers for this app (from PR config)
-                    impactedServers := make(map[string]bool)
-                    for _, s := range diff.PRConfig.Whitelists {
-                        impactedServers[s] = true
-                    }
-                    for _, m := range diff.PRConfig.CMDBWhitelists {
-                        for _, v := range m {
-                            impactedServers[v] = true
+                    impactedServers, emptyQueries, err := computeImpactedServers(ctx, diff.PRConfig)
+                    if err != nil {
+                        // cmdb_failure_action decides whether a CMDB outage blocks the PR
+                        lg.Printf("  Could not compute impacted servers (cmdb_failure_action %s): %v", config.CMDBFailureAction, err)
+                        fmt.Fprintf(rep, "  Could not compute impacted servers: %v\n", err)
+                        msg := fmt.Sprintf("could not compute impacted servers for %s: %v", diff.Name, err)
+                        // A group the CMDB doesn't know is a mistake in the PR, not an outage
+                        if errors.Is(err, errUnknownCMDBGroup) {
+                            violations = append(violations, fmt.Sprintf("app %s references a CMDB group that can't be resolved: %v", diff.Name, err))
+                            continue
                         }
+                        switch config.CMDBFailureAction {
+                        case "fail":
+                            violations = append(violations, msg)
+                            continue
+                        case "skip":
+                            continue
+                        }
+                        warnings = append(warnings, msg+"; using static whitelists only")
+                        impactedServers = staticImpactedServers(diff.PRConfig)
                     }
-                    for _, s := range diff.PRConfig.Blacklists {
-                        delete(impactedServers, s)
+                    for _, q := range emptyQueries {
+                        msg := fmt.Sprintf("cmdb_whitelists entry %s of app %s matches no servers", q, diff.Name)
+                        lg.Printf("  Warning: %s", msg)
+                        fmt.Fprintf(rep, "  Warning: %s\n", msg)
+                        warnings = append(warnings, msg)
                     }
-                    for _, m := range diff.PRConfig.CMDBBlacklists {
-                        for _, v := range m {
-                            delete(impactedServers, v)
+                    pc.AppServers[diff.Name] = impactedServers
+                    for s := range impactedServers {
+                        pc.ImpactedServers[s] = true
+                        if isProdServer(s) {
+                            pc.ProdServers[s] = true
                         }
                     }
-                    log.Printf("  Impacted servers: %v", impactedServers)
-                    fmt.Fprintf(w, "  Impacted servers: %v\n", impactedServers)
+                    serverList := strings.Join(sortedKeys(impactedServers), ", ")
+                    lg.Printf("  Impacted servers: %s", serverList)
+                    fmt.Fprintf(rep, "  Impacted servers: %s\n", serverList)
+                    // Compare against the servers the app impacts at the PR base
+                    baseServers := make(map[string]bool)
+                    if _, existed := mainAppsMap[diff.Name]; existed {
+                        baseServers, _, err = computeImpactedServers(ctx, diff.MainConfig)
+                        if err != nil {
+                            lg.Printf("  Could not compute impacted servers at the PR base: %v", err)
+                            continue
+                        }
+                    }
+                    pc.BaseAppServers[diff.Name] = baseServers
+                    added, removed := impactDelta(baseServers, impactedServers)
+                    lg.Printf("  Changed servers: added: [%s], removed: [%s]", strings.Join(added, ", "), strings.Join(removed, ", "))
+                    fmt.Fprintf(rep, "  Changed servers: added: [%s], removed: [%s]\n", strings.Join(added, ", "), strings.Join(removed, ", "))
+                }
+            }
+
+            // Tie changed modules back to the servers their app impacts
+            if len(changedFiles) > 0 {
+                prAppsMap := make(map[string]App)
+                for _, app := range prAppsJson.Apps {
+                    prAppsMap[app.Name] = app
+                }
+                seenModules := make(map[string]bool)
+                var moduleLines []string
+                for _, cf := range changedFiles {
+                    module := cf.AppName + "/" + cf.ModuleName
+                    app, ok := prAppsMap[cf.AppName]
+                    if !ok || seenModules[module] {
+                        continue
+                    }
+                    seenModules[module] = true
+                    servers, _, err := computeImpactedServers(ctx, app)
+                    if err != nil {
+                        lg.Printf("Could not compute impacted servers for module %s: %v", module, err)
+                        continue
+                    }
+                    moduleLines = append(moduleLines, fmt.Sprintf("- %s: %s", module, strings.Join(sortedKeys(servers), ", ")))
+                }
+                if len(moduleLines) > 0 {
+                    sort.Strings(moduleLines)
+                    lg.Printf("Impacted servers by module:\n%s", strings.Join(moduleLines, "\n"))
+                    fmt.Fprintf(rep, "Impacted servers by module:\n%s\n", strings.Join(moduleLines, "\n"))
                 }
             }
     }
 
-    // --- Enhanced PR Validation Logic ---
+    if msg := checkChangedFileCount(pc.Files); msg != "" {
+        lg.Printf("Violation: %s", msg)
+        fmt.Fprintf(rep, "Violation: %s\n", msg)
+        violations = append(violations, msg)
+    }
+
+    // GitHub omits the patch of very large files; content rules can't scan them without one
+    for _, f := range fillOmittedPatches(ctx, pc) {
+        msg := fmt.Sprintf("%s is too large for GitHub to include its patch and was not scanned", f)
+        lg.Printf("Warning: %s", msg)
+        fmt.Fprintf(rep, "Warning: %s\n", msg)
+        warnings = append(warnings, msg)
+    }
+
+    // Run the configured rules; their failures fail the PR and their warnings are reported
+    selected, profile := selectRules(pc.Labels)
+    if profile != "" {
+        lg.Printf("Using rule profile %q for PR #%d", profile, pc.Number)
+        fmt.Fprintf(rep, "Using rule profile %q\n", profile)
+    }
+    results := runRules(ctx, pc, selected)
+    for _, res := range results {
+        // Most rules are opt-in, so skipping an unconfigured one is only worth a debug line
+        if res.Skipped && res.SkipReason == "not configured" {
+            debugf(ctx, "Rule %s skipped (%s)", res.Rule, res.SkipReason)
+            continue
+        }
+        if res.Skipped {
+            lg.Printf("Rule %s skipped (%s)", res.Rule, res.SkipReason)
+            fmt.Fprintf(rep, "Rule %s skipped (%s)\n", res.Rule, res.SkipReason)
+            continue
+        }
+        for _, f := range res.Failures {
+            lg.Printf("Rule %s failed: %s", res.Rule, f)
+            fmt.Fprintf(rep, "Rule %s failed: %s\n", res.Rule, f)
+            violations = append(violations, f)
+        }
+        for _, warning := range res.Warnings {
+            lg.Printf("Rule %s warning: %s", res.Rule, warning)
+            fmt.Fprintf(rep, "Rule %s warning: %s\n", res.Rule, warning)
+            warnings = append(warnings, warning)
+        }
+        for _, p := range res.Pending {
+            lg.Printf("Rule %s pending: %s", res.Rule, p)
+            fmt.Fprintf(rep, "Rule %s pending: %s\n", res.Rule, p)
+            pending = append(pending, p)
+        }
+        if res.Err != nil {
+            fmt.Fprintf(rep, "Rule %s could not be evaluated: %v\n", res.Rule, res.Err)
+            warnings = append(warnings, fmt.Sprintf("rule %s could not be evaluated: %v", res.Rule, res.Err))
+        }
+    }
+
+    // The file rules apply to every PR, like max_changed_files above
+    fileViolations := validatePR(pc.Files)
+    for _, msg := range fileViolations {
+        lg.Printf("Violation: %s", msg)
+        fmt.Fprintf(rep, "Violation: %s\n", msg)
+        violations = append(violations, msg)
+    }
+
     onlyAppsJsonChanged := false
-    changedAppModules := make(map[string][]string)
-    for _, f := range files {
+    for _, f := range pc.Files {
         if f.Filename == "apps.json" {
             onlyAppsJsonChanged = true
-        } else {
-            parts := bytes.Split([]byte(f.Filename), []byte("/"))
-            if len(parts) >= 3 {
-                appName := string(parts[0])
-                moduleName := string(parts[1])
-                changedAppModules[appName] = append(changedAppModules[appName], moduleName)
-                onlyAppsJsonChanged = false
-            }
+        } else if _, _, _, ok := splitAppPath(f.Filename); ok {
+            onlyAppsJsonChanged = false
+            break
         }
     }
 
     status := "success"
     description := "PR validation passed."
     comment := ""
-
-    if onlyAppsJsonChanged && len(changedAppModules) == 0 {
-        status = "success"
+    if onlyAppsJsonChanged {
         description = "Only apps.json changed, no app changes."
         comment = "Only apps.json changed, no app changes."
-    } else {
-        // Check if any module is fluent_bit
-        fluentBitFound := false
-        for _, modules := range changedAppModules {
-            for _, m := range modules {
-                if m == "fluent-bit" {
-                    fluentBitFound = true
-                    break
-                }
-            }
-            if fluentBitFound {
-                break
-            }
+    }
+
+    if len(violations) > 0 {
+        status = "failure"
+        description = strings.Join(violations, "; ")
+        comment = "PR rejected: " + description
+    } else if len(pending) > 0 && status == "success" {
+        status = "pending"
+        description = strings.Join(pending, "; ")
+        comment = "PR awaiting review: " + description
+    }
+    if len(warnings) > 0 {
+        comment += "\nWarnings: " + strings.Join(warnings, "; ")
+    }
+
+    return validation{
+        Status:         status,
+        Description:    description,
+        Comment:        comment,
+        Profile:        profile,
+        Results:        results,
+        FileViolations: fileViolations,
+        Violations:     violations,
+        Warnings:       warnings,
+        Pending:        pending,
+    }
+}
+
+// reopenNeedsCommit is posted on failed PRs reopened under reopen_action require-new-commit
+const reopenNeedsCommit = "This pull request failed validation before it was closed. Push a commit that addresses the failures and it will be validated again."
+
+// failureAction is what FAILURE_ACTION asks for when a PR fails validation: "close",
+// "comment" (a review comment with the summary) or "status-only"
+var failureAction = "status-only"
+
+// validatePR checks each changed file against the file rules from RULES_PATH and
+// returns a message per violation
+func validatePR(files []PRFile) []string {
+    var violations []string
+    for _, f := range files {
+        if f.Status == "removed" {
+            continue
         }
-        if fluentBitFound {
-            // Validate PR for fluent_bit modules
-            validationPassed := validatePR(files)
-            if validationPassed {
-                status = "success"
-                description = "PR validation passed for fluent_bit module."
-                comment = "PR validation passed for fluent_bit module."
-            } else {
-                status = "failure"
-                description = "PR validation failed for fluent_bit module."
-                comment = "PR rejected: validation failed for fluent_bit module."
+        for _, re := range fileRules.pathRes {
+            if re.MatchString(f.Filename) {
+                violations = append(violations, fmt.Sprintf("%s matches forbidden path pattern %s", f.Filename, re))
             }
-        } else {
-            // No fluent_bit modules updated
-            status = "success"
-            description = "Modules updated do not belong to fluent_bit, validation skipped."
-            comment = "Modules updated do not belong to fluent_bit, validation skipped."
+        }
+        if fileRules.MaxAdditionsPerFile > 0 && f.Additions > fileRules.MaxAdditionsPerFile {
+            violations = append(violations, fmt.Sprintf("%s adds %d lines, limit is %d", f.Filename, f.Additions, fileRules.MaxAdditionsPerFile))
+        }
+        if isBinaryFile(f) && !matchesAny(f.Filename, fileRules.AllowedBinaryPaths) {
+            violations = append(violations, fmt.Sprintf("%s is a binary file, which may not be committed", f.Filename))
+        }
+        if changed := f.Additions + f.Deletions; fileRules.MaxChangesPerFile > 0 && changed > fileRules.MaxChangesPerFile {
+            violations = append(violations, fmt.Sprintf("%s changes %d lines (+%d -%d), limit is %d", f.Filename, changed, f.Additions, f.Deletions, fileRules.MaxChangesPerFile))
         }
     }
+    return violations
+}
 
-    // Update PR status on GitHub (do not close PR if failed)
-    err = updatePRStatus(owner, repo, prNumber, status, description)
-    if err != nil {
-        log.Printf("Error updating PR status: %v", err)
+// isBinaryFile reports whether f has one of the binary_extensions or, with reject_binary_files,
+// was added or modified with changes but no patch, which is how GitHub reports binaries. Empty
+// files have neither. Files with one of the text_extensions are text too large for a patch,
+// which fillOmittedPatches reports instead.
+func isBinaryFile(f PRFile) bool {
+    ext := strings.ToLower(path.Ext(f.Filename))
+    if ext != "" && containsFold(fileRules.BinaryExtensions, ext) {
+        return true
     }
-    // Optionally add a comment to the PR (implement addPRComment if needed)
-    if comment != "" {
+    if ext != "" && containsFold(fileRules.TextExtensions, ext) {
+        return false
+    }
+    return fileRules.RejectBinaryFiles && (f.Status == "added" || f.Status == "modified") && f.Patch == "" && f.Changes > 0
+}
 
-        // addPRComment(owner, repo, prNumber, comment) // Uncomment and implement if you want to post comments
-        log.Printf("PR #%d comment: %s", prNumber, comment)
+// checkChangedFileCount returns a violation when the PR changes more files than max_changed_files allows
+func checkChangedFileCount(files []PRFile) string {
+    if fileRules.MaxChangedFiles <= 0 || len(files) <= fileRules.MaxChangedFiles {
+        return ""
     }
-    fmt.Fprintf(w, "PR #%d validation complete. Status: %s\n", prNumber, status)
-    fmt.Fprintf(w, "Files changed in PR:\n")
-    for _, f := range files {
-        fmt.Fprintf(w, "- %s (additions: %d, deletions: %d, changes: %d)\n", f.Filename, f.Additions, f.Deletions, f.Changes)
+    return fmt.Sprintf("PR changes %d files, limit is %d.", len(files), fileRules.MaxChangedFiles)
+}
+
+// failureLabels returns the failure_labels configured for rules that failed, without duplicates
+func failureLabels(results []ruleResult) []string {
+    seen := make(map[string]bool)
+    var labels []string
+    for _, res := range results {
+        if len(res.Failures) == 0 {
+            continue
+        }
+        for _, l := range config.FailureLabels[res.Rule] {
+            if !seen[l] {
+                seen[l] = true
+                labels = append(labels, l)
+            }
+        }
     }
+    return labels
 }
 
-// validatePR runs custom validation logic on PR files
-func validatePR(files []PRFile) bool {
-    // TODO: Add your validation logic here
-    // Example: return false if any file is named "forbidden.txt"
-    for _, f := range files {
-        if f.Filename == "forbidden.txt" {
-            return false
+// containsFold reports whether list contains s, ignoring case as GitHub logins do
+func containsFold(list []string, s string) bool {
+    for _, v := range list {
+        if strings.EqualFold(v, s) {
+            return true
         }
     }
     return false
 }
 
-// updatePRStatus posts a status to the PR using the GitHub API
-func updatePRStatus(owner, repo string, prNumber int, state, description string) error {
-    // Get PR details to find the head SHA
-    prURL := fmt.Sprintf("https://api.github.com/repos/%s/%s/pulls/%d", owner, repo, prNumber)
-    token := os.Getenv("GITHUB_TOKEN")
-    req, err := http.NewRequest("GET", prURL, nil)
-    if err != nil {
-        return err
-    }
-    req.Header.Set("Authorization", "token "+token)
-    req.Header.Set("Accept", "application/vnd.github.v3+json")
-    client := &http.Client{}
-    resp, err := client.Do(req)
-    if err != nil {
-        return err
+// checkSameRepo returns a message when a PR comes from a different owner than its base repo
+func checkSameRepo(details *PRDetails) string {
+    headOwner := details.Head.Repo.Owner.Login
+    baseOwner := details.Base.Repo.Owner.Login
+    if headOwner == baseOwner {
+        return ""
     }
-    defer resp.Body.Close()
-    if resp.StatusCode != 200 {
-        body, _ := ioutil.ReadAll(resp.Body)
-        return fmt.Errorf("GitHub API error: %s", string(body))
+    if headOwner == "" {
+        return fmt.Sprintf("PR head repository is unavailable, only PRs from %s are accepted", details.Base.Repo.FullName)
     }
-    var prData struct {
-        Head struct {
-            SHA string `json:"sha"`
-        } `json:"head"`
-    }
-    decoder := json.NewDecoder(resp.Body)
-    if err := decoder.Decode(&prData); err != nil {
-        return err
+    return fmt.Sprintf("PR comes from %s, only PRs from %s are accepted", details.Head.Repo.FullName, details.Base.Repo.FullName)
+}
+
+// postRollupStatus posts a commitvalidator/<rule> status per rule that ran, then
+// commitvalidator/all: failure when the main status or any rule failed, else pending when
+// any is pending, else success. A status that can't be posted doesn't stop the rest, and
+// the first such error is returned.
+func postRollupStatus(ctx context.Context, owner, repo, sha, mainState string, results []ruleResult) error {
+    var postErr error
+    total, failed, pending := 1, 0, 0
+    switch mainState {
+    case "failure", "error":
+        failed++
+    case "pending":
+        pending++
     }
-    sha := prData.Head.SHA
-    // Set status on the commit
-    statusURL := fmt.Sprintf("https://api.github.com/repos/%s/%s/statuses/%s", owner, repo, sha)
-    statusBody := map[string]string{
-        "state": state,
-        "description": description,
-        "context": "commitvalidator",
+    for _, res := range results {
+        if res.Skipped {
+            continue
+        }
+        state, description := "success", "Passed."
+        if res.Err != nil {
+            state, description = "error", res.Err.Error()
+        } else if len(res.Failures) > 0 {
+            state, description = "failure", strings.Join(res.Failures, "; ")
+        } else if len(res.Pending) > 0 {
+            state, description = "pending", strings.Join(res.Pending, "; ")
+        }
+        if err := githubAPI.PostCommitStatus(ctx, owner, repo, sha, "commitvalidator/"+res.Rule, state, description, ""); err != nil && postErr == nil {
+            postErr = fmt.Errorf("posting commitvalidator/%s: %w", res.Rule, err)
+        }
+        total++
+        switch state {
+        case "error", "failure":
+            failed++
+        case "pending":
+            pending++
+        }
     }
-    bodyBytes, _ := json.Marshal(statusBody)
-    req, err = http.NewRequest("POST", statusURL, bytes.NewBuffer(bodyBytes))
-    if err != nil {
-        return err
+    state, description := "success", fmt.Sprintf("All %d checks passed.", total)
+    if failed > 0 {
+        state, description = "failure", fmt.Sprintf("%d of %d checks did not pass.", failed, total)
+    } else if pending > 0 {
+        state, description = "pending", fmt.Sprintf("%d of %d checks are pending.", pending, total)
     }
-    req.Header.Set("Authorization", "token "+token)
-    req.Header.Set("Accept", "application/vnd.github.v3+json")
-    req.Header.Set("Content-Type", "application/json")
-    resp, err = client.Do(req)
-    if err != nil {
+    if err := githubAPI.PostCommitStatus(ctx, owner, repo, sha, "commitvalidator/all", state, description, ""); err != nil {
         return err
     }
-    defer resp.Body.Close()
-    if resp.StatusCode != 201 {
-        body, _ := ioutil.ReadAll(resp.Body)
-        return fmt.Errorf("GitHub API error: %s", string(body))
-    }
-    log.Printf("PR #%d [%s/%s] status updated to %s: %s", prNumber, owner, repo, state, description)
-    return nil
+    return postErr
 }
 
-// closePullRequest closes the PR using the GitHub API
-func closePullRequest(owner, repo string, prNumber int) error {
-    token := os.Getenv("GITHUB_TOKEN")
-    url := fmt.Sprintf("https://api.github.com/repos/%s/%s/pulls/%d", owner, repo, prNumber)
-    body := map[string]string{"state": "closed"}
-    bodyBytes, _ := json.Marshal(body)
-    req, err := http.NewRequest("PATCH", url, bytes.NewBuffer(bodyBytes))
-    if err != nil {
-        return err
+// postMandatoryPathsStatus posts commitvalidator/mandatory-paths, mirroring the main
+// status, when the PR changes any of mandatory_status_paths
+func postMandatoryPathsStatus(ctx context.Context, owner, repo, sha, mainState string, files []PRFile) error {
+    var matched []string
+    for _, f := range files {
+        if matchesAny(f.Filename, config.MandatoryStatusPaths) {
+            matched = append(matched, f.Filename)
+        }
     }
-    req.Header.Set("Authorization", "token "+token)
-    req.Header.Set("Accept", "application/vnd.github.v3+json")
-    req.Header.Set("Content-Type", "application/json")
-    client := &http.Client{}
-    resp, err := client.Do(req)
-    if err != nil {
-        return err
+    if len(matched) == 0 {
+        return nil
     }
-    defer resp.Body.Close()
-    if resp.StatusCode != 200 {
-        body, _ := ioutil.ReadAll(resp.Body)
-        return fmt.Errorf("GitHub API error: %s", string(body))
+    description := fmt.Sprintf("Validated %d sensitive path(s): %s", len(matched), strings.Join(matched, ", "))
+    return githubAPI.PostCommitStatus(ctx, owner, repo, sha, "commitvalidator/mandatory-paths", mainState, description, "")
+}
+
+// recentCloses tracks when each PR was last closed by the validator
+var recentCloses = struct {
+    sync.Mutex
+    m map[string]time.Time
+}{m: make(map[string]time.Time)}
+
+// prKey identifies a PR across repos
+func prKey(owner, repo string, prNumber int) string {
+    return fmt.Sprintf("%s/%s#%d", owner, repo, prNumber)
+}
+
+// recordClose remembers that the PR was just closed, pruning entries past the cooldown
+func recordClose(owner, repo string, prNumber int) {
+    now := time.Now()
+    recentCloses.Lock()
+    defer recentCloses.Unlock()
+    for k, t := range recentCloses.m {
+        if now.Sub(t) > config.CloseCooldown.Duration {
+            delete(recentCloses.m, k)
+        }
     }
-    log.Printf("PR #%d [%s/%s] has been closed after validation.", prNumber, owner, repo)
-    return nil
+    recentCloses.m[prKey(owner, repo, prNumber)] = now
 }
 
-// PRFile represents a file changed in a PR
-type PRFile struct {
-    Filename  string `json:"filename"`
-    Additions int    `json:"additions"`
-    Deletions int    `json:"deletions"`
-    Changes   int    `json:"changes"`
-    Status    string `json:"status"`
-    RawURL    string `json:"raw_url"`
-    BlobURL   string `json:"blob_url"`
-    Patch     string `json:"patch"`
+// inCloseCooldown reports whether the PR was closed within the configured cooldown
+func inCloseCooldown(owner, repo string, prNumber int) bool {
+    recentCloses.Lock()
+    defer recentCloses.Unlock()
+    t, ok := recentCloses.m[prKey(owner, repo, prNumber)]
+    return ok && time.Since(t) < config.CloseCooldown.Duration
 }
 
-// fetchPRFiles gets the list of changed files for a PR from GitHub
-func fetchPRFiles(owner, repo string, prNumber int) ([]PRFile, error) {
-    url := fmt.Sprintf("https://api.github.com/repos/%s/%s/pulls/%d/files", owner, repo, prNumber)
-    req, err := http.NewRequest("GET", url, nil)
-    if err != nil {
-        return nil, err
-    }
-    // Optionally set a GitHub token for private repos or higher rate limits
-    // token := os.Getenv("GITHUB_TOKEN")
-    // if token != "" {
-    //     req.Header.Set("Authorization", "token "+token)
-    // }
-    req.Header.Set("Accept", "application/vnd.github.v3+json")
-    client := &http.Client{}
-    resp, err := client.Do(req)
-    if err != nil {
-        return nil, err
+// closeFailedPR closes a PR that failed validation, unless it was just reopened by an admin
+// or reopened during the close cooldown. The close is retried on 5xx and network errors like
+// every GitHub call (GITHUB_MAX_RETRIES); a close that still fails raises an alert.
+func closeFailedPR(ctx context.Context, owner, repo string, prNumber int, action string) error {
+    if action == "reopened" && takeAdminReopened(owner, repo, prNumber) {
+        loggerFrom(ctx).Printf("PR #%d [%s/%s] was reopened through /admin/reopen, leaving it open", prNumber, owner, repo)
+        return nil
     }
-    defer resp.Body.Close()
-    if resp.StatusCode != 200 {
-        body, _ := ioutil.ReadAll(resp.Body)
-        return nil, fmt.Errorf("GitHub API error: %s", string(body))
+    if action == "reopened" && inCloseCooldown(owner, repo, prNumber) {
+        loggerFrom(ctx).Printf("PR #%d [%s/%s] reopened within close cooldown of %s, leaving it open", prNumber, owner, repo, config.CloseCooldown)
+        return nil
     }
-    var files []PRFile
-    decoder := json.NewDecoder(resp.Body)
-    if err := decoder.Decode(&files); err != nil {
-        return nil, err
+    err := githubAPI.ClosePR(ctx, owner, repo, prNumber)
+    if err == nil {
+        audit.Record(auditEntry{Kind: auditClose, Repo: owner + "/" + repo, PR: prNumber})
+        return nil
     }
-    return files, nil
+    audit.Record(auditEntry{Kind: auditCloseFailed, Repo: owner + "/" + repo, PR: prNumber, Detail: err.Error()})
+    sendAlert(fmt.Sprintf("Could not close failing PR %s/%s#%d: %v", owner, repo, prNumber, err))
+    return err
 }
 
 func main() {
+    setupLogging(os.Getenv("LOG_FORMAT"))
+    // API bases are set first; the self-test, summaries and CLI all call the API
+    if u := os.Getenv("GITHUB_API_BASE"); u != "" {
+        githubAPIBase = strings.TrimRight(u, "/")
+    }
+    if u := os.Getenv("GITLAB_API_URL"); u != "" {
+        gitlabAPIBase = u
+    }
+    configPath := os.Getenv("CONFIG_PATH")
+    if configPath == "" {
+        configPath = "config.json"
+    }
+    cfg, err := loadConfig(configPath)
+    if err != nil {
+        log.Fatalf("Could not load config %s: %v", configPath, err)
+    }
+    config = cfg
+    // Only the default rules.json may be missing; a RULES_PATH that can't be read is a mistake
+    rulesPath := os.Getenv("RULES_PATH")
+    rulesRequired := rulesPath != ""
+    if rulesPath == "" {
+        rulesPath = "rules.json"
+    }
+    if fileRules, err = loadFileRules(rulesPath, rulesRequired); err != nil {
+        log.Fatalf("Could not load rules %s: %v", rulesPath, err)
+    }
+    fileRules.MaxChangedFiles = envInt("MAX_CHANGED_FILES", fileRules.MaxChangedFiles)
+    // A local INVENTORY_PATH export takes precedence over the CMDB_API_URL service
+    if path := os.Getenv("INVENTORY_PATH"); path != "" {
+        poll := time.Duration(envInt("INVENTORY_POLL_SECONDS", 10)) * time.Second
+        debounce := time.Duration(envInt("INVENTORY_DEBOUNCE_SECONDS", 2)) * time.Second
+        inv, err := newFileInventory(path, poll, debounce)
+        if err != nil {
+            log.Fatalf("Could not load inventory %s: %v", path, err)
+        }
+        cmdbResolver = inv
+    } else if cmdbURL := os.Getenv("CMDB_API_URL"); cmdbURL != "" {
+        cmdbResolver = httpCMDBResolver{baseURL: cmdbURL}
+    }
+    if u := os.Getenv("MAINTENANCE_API_URL"); u != "" {
+        maintenanceSource = httpMaintenanceSource{baseURL: u}
+    }
+    deliveries = newDedupStore(envDuration("DEDUP_TTL", time.Hour), envInt("DEDUP_MAX_ENTRIES", 10000))
+    githubClient.Timeout = envDuration("GITHUB_HTTP_TIMEOUT", githubClient.Timeout)
+    githubRateLimitMaxWait = envDuration("GITHUB_RATE_LIMIT_MAX_WAIT", githubRateLimitMaxWait)
+    githubMaxRetries = envInt("GITHUB_MAX_RETRIES", githubMaxRetries)
+    githubRetryBackoff = envDuration("GITHUB_RETRY_BACKOFF", githubRetryBackoff)
+    maxBodyBytes = int64(envInt("GITHUB_MAX_BODY_BYTES", int(maxBodyBytes)))
+    dryRun = envBool("DRY_RUN")
+    switch a := os.Getenv("FAILURE_ACTION"); a {
+    case "":
+    case "close", "comment", "status-only":
+        failureAction = a
+    default:
+        log.Fatalf("Invalid FAILURE_ACTION %q, expected close, comment or status-only", a)
+    }
+    if strings.EqualFold(os.Getenv("LOG_LEVEL"), "debug") {
+        logLevel.Set(slog.LevelDebug)
+    }
+    if p := os.Getenv("APPS_JSON_PATH"); p != "" {
+        appsJsonPath = p
+    }
+    webhookSecret = os.Getenv("WEBHOOK_SECRET")
+    if webhookSecret == "" {
+        log.Printf("WEBHOOK_SECRET is not set, webhook signatures will not be verified")
+    }
+    useChecksAPI = envBool("USE_CHECKS_API")
+    if dryRun {
+        log.Printf("DRY_RUN is set, GitHub requests that change state will be logged instead of sent")
+    }
+
+    if path := os.Getenv("AUDIT_LOG_PATH"); path != "" {
+        if err := audit.open(path); err != nil {
+            log.Fatalf("Could not open audit log %s: %v", path, err)
+        }
+    }
+
+    // ANALYTICS_SINK receives a JSON event per validation: stdout, file:<path> or an http(s) URL
+    if sink := os.Getenv("ANALYTICS_SINK"); sink != "" {
+        if err := startAnalyticsSink(sink); err != nil {
+            log.Fatalf("Could not start analytics sink: %v", err)
+        }
+    }
+
+    // SUMMARY_TARGET gets a digest of validations every SUMMARY_INTERVAL (default 24h):
+    // a Slack-compatible webhook URL or an "owner/repo#number" issue
+    if target := os.Getenv("SUMMARY_TARGET"); target != "" {
+        if err := startSummaries(envDuration("SUMMARY_INTERVAL", 24*time.Hour), target); err != nil {
+            log.Fatalf("Could not start summaries: %v", err)
+        }
+    }
+
+    // STARTUP_SELF_TEST is "warn" to log or "fail" to exit when GitHub auth doesn't work
+    switch mode := os.Getenv("STARTUP_SELF_TEST"); mode {
+    case "":
+    case "warn", "fail":
+        if err := selfTest(); err != nil {
+            if mode == "fail" {
+                log.Fatalf("GitHub self-test failed: %v", err)
+            }
+            log.Printf("Warning: GitHub self-test failed: %v", err)
+        }
+    default:
+        log.Fatalf("Invalid STARTUP_SELF_TEST %q, expected warn or fail", mode)
+    }
+
+    // `commitvalidator validate owner/repo#N` validates one PR and exits instead of serving
+    if len(os.Args) > 1 {
+        os.Exit(runCLI(os.Args[1:]))
+    }
     http.HandleFunc("/webhook", prWebhookHandler)
-    port := "8080"
-    log.Printf("Server listening on port %s", port)
-    log.Fatal(http.ListenAndServe(":"+port, nil))
+    http.HandleFunc("/gitlab/webhook", gitlabWebhookHandler)
+    http.HandleFunc("/healthz", healthzHandler)
+    http.HandleFunc("/metrics", metricsHandler)
+    adminToken := os.Getenv("ADMIN_TOKEN")
+    if adminToken == "" {
+        log.Printf("ADMIN_TOKEN is not set, all /admin/ requests will be rejected")
+    }
+    adminAuth := bearerTokenAuthenticator{token: adminToken}
+    http.Handle("/admin/", requireAdmin(adminAuth, adminMux))
+    http.Handle("/config", requireAdmin(adminAuth, http.HandlerFunc(configHandler)))
+    port := os.Getenv("PORT")
+    if port == "" {
+        port = "8080"
+    }
+    // BIND_ADDR restricts the listener to one interface, e.g. 127.0.0.1 behind a reverse proxy
+    addr := net.JoinHostPort(os.Getenv("BIND_ADDR"), port)
+    log.Printf("Server listening on %s (port %s)", addr, port)
+    log.Fatal(http.ListenAndServe(addr, nil))
 }
\ No newline at end of file
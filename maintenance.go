@@ -0,0 +1,68 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+)
+
+// MaintenanceWindow is a period during which a server may be changed
+type MaintenanceWindow struct {
+    Start time.Time `json:"start"`
+    End   time.Time `json:"end"`
+}
+
+// MaintenanceSource looks up a server's scheduled maintenance windows
+type MaintenanceSource interface {
+    Windows(ctx context.Context, server string) ([]MaintenanceWindow, error)
+}
+
+// maintenanceSource provides maintenance windows; nil disables the maintenance-windows rule
+var maintenanceSource MaintenanceSource
+
+// httpMaintenanceSource queries GET {baseURL}/windows?server={server}, which responds
+// with {"windows": [{"start": "<RFC 3339>", "end": "<RFC 3339>"}, ...]}
+type httpMaintenanceSource struct {
+    baseURL string
+}
+
+// Windows returns the windows the schedule lists for server
+func (m httpMaintenanceSource) Windows(ctx context.Context, server string) ([]MaintenanceWindow, error) {
+    u := strings.TrimRight(m.baseURL, "/") + "/windows?" + url.Values{"server": {server}}.Encode()
+    req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+    if err != nil {
+        return nil, err
+    }
+    client := &http.Client{Timeout: 10 * time.Second}
+    resp, err := client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != 200 {
+        body, _ := ioutil.ReadAll(resp.Body)
+        return nil, fmt.Errorf("maintenance API error (%d): %s", resp.StatusCode, string(body))
+    }
+    var result struct {
+        Windows []MaintenanceWindow `json:"windows"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+        return nil, err
+    }
+    return result.Windows, nil
+}
+
+// coveredByWindow reports whether any window is active now or starts within lookahead
+func coveredByWindow(windows []MaintenanceWindow, now time.Time, lookahead time.Duration) bool {
+    for _, w := range windows {
+        if w.End.After(now) && w.Start.Before(now.Add(lookahead)) {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// seenRepos is every owner/repo the webhook has handled a PR event for
+var seenRepos = struct {
+    sync.Mutex
+    m map[string]bool
+}{m: make(map[string]bool)}
+
+// recordRepo adds owner/repo to the repos served
+func recordRepo(owner, repo string) {
+    seenRepos.Lock()
+    defer seenRepos.Unlock()
+    seenRepos.m[owner+"/"+repo] = true
+}
+
+// reposServed lists the repos served so far, sorted
+func reposServed() []string {
+    seenRepos.Lock()
+    defer seenRepos.Unlock()
+    return sortedKeys(seenRepos.m)
+}
+
+func init() {
+    adminMux.HandleFunc("/admin/repos", reposHandler)
+}
+
+// reposHandler lists the repos the webhook has handled PR events for since startup
+func reposHandler(w http.ResponseWriter, r *http.Request) {
+    repos := reposServed()
+    if repos == nil {
+        repos = []string{}
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(struct {
+        Repos []string `json:"repos"`
+    }{repos})
+}
+
+// counterVec is a Prometheus counter with labels, keyed by its label values
+type counterVec struct {
+    name, help string
+    labels     []string
+    mu         sync.Mutex
+    values     map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+    return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+// Inc adds one to the counter for the given label values, in the order of c.labels
+func (c *counterVec) Inc(values ...string) {
+    pairs := make([]string, len(c.labels))
+    for i, l := range c.labels {
+        pairs[i] = fmt.Sprintf("%s=%s", l, strconv.Quote(values[i]))
+    }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.values[strings.Join(pairs, ",")]++
+}
+
+func (c *counterVec) write(w io.Writer) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+    keys := make([]string, 0, len(c.values))
+    for k := range c.values {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    for _, k := range keys {
+        fmt.Fprintf(w, "%s{%s} %g\n", c.name, k, c.values[k])
+    }
+}
+
+// histogram is a Prometheus histogram without labels
+type histogram struct {
+    name, help string
+    buckets    []float64
+    mu         sync.Mutex
+    counts     []uint64
+    sum        float64
+    count      uint64
+}
+
+func newHistogram(name, help string, buckets ...float64) *histogram {
+    return &histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+// Observe records one value
+func (h *histogram) Observe(v float64) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    for i, b := range h.buckets {
+        if v <= b {
+            h.counts[i]++
+        }
+    }
+    h.sum += v
+    h.count++
+}
+
+func (h *histogram) write(w io.Writer) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+    for i, b := range h.buckets {
+        fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", h.name, b, h.counts[i])
+    }
+    fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %g\n%s_count %d\n", h.name, h.count, h.name, h.sum, h.name, h.count)
+}
+
+var (
+    webhooksReceived   = newCounterVec("commitvalidator_webhooks_received_total", "Webhook deliveries received.", "event", "action")
+    validationsTotal   = newCounterVec("commitvalidator_validations_total", "PR validations by resulting status.", "status")
+    githubRequests     = newCounterVec("commitvalidator_github_requests_total", "GitHub API requests by endpoint and status code.", "endpoint", "code")
+    githubReqDurations = newHistogram("commitvalidator_github_request_duration_seconds", "GitHub API request durations.",
+        0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30)
+)
+
+// githubPathWords are the fixed segments of GitHub API paths; anything else is a parameter
+var githubPathWords = map[string]bool{
+    "repos": true, "pulls": true, "issues": true, "comments": true, "statuses": true, "check-runs": true,
+    "files": true, "commits": true, "reviews": true, "labels": true, "contents": true, "git": true,
+    "trees": true, "blobs": true, "compare": true, "orgs": true, "teams": true, "memberships": true,
+    "user": true, "rate_limit": true,
+}
+
+// githubEndpoint reduces a GitHub API URL to a low-cardinality label like
+// /repos/:param/pulls/:param/files
+func githubEndpoint(u string) string {
+    u = strings.TrimPrefix(u, githubAPIBase)
+    if i := strings.IndexByte(u, '?'); i >= 0 {
+        u = u[:i]
+    }
+    var segs []string
+    for _, seg := range strings.Split(strings.Trim(u, "/"), "/") {
+        if !githubPathWords[seg] {
+            seg = ":param"
+            // Runs of parameters, like a contents path, collapse into one
+            if len(segs) > 0 && segs[len(segs)-1] == seg {
+                continue
+            }
+        }
+        segs = append(segs, seg)
+    }
+    return "/" + strings.Join(segs, "/")
+}
+
+// observeGitHubRequest records one GitHub API attempt; code 0 means it failed before a response
+func observeGitHubRequest(req *http.Request, code int, elapsed time.Duration) {
+    label := "error"
+    if code != 0 {
+        label = strconv.Itoa(code)
+    }
+    githubRequests.Inc(githubEndpoint(req.URL.String()), label)
+    githubReqDurations.Observe(elapsed.Seconds())
+}
+
+// metricsHandler serves the metrics in the Prometheus text format
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+    webhooksReceived.write(w)
+    validationsTotal.write(w)
+    githubRequests.write(w)
+    githubReqDurations.write(w)
+    fmt.Fprintf(w, "# HELP commitvalidator_unique_repos Repos the webhook has handled PR events for.\n# TYPE commitvalidator_unique_repos gauge\ncommitvalidator_unique_repos %d\n", len(reposServed()))
+    fmt.Fprintf(w, "# HELP commitvalidator_dedup_entries Delivery IDs held for deduplication.\n# TYPE commitvalidator_dedup_entries gauge\ncommitvalidator_dedup_entries %d\n", deliveries.Len())
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "strings"
+)
+
+// newReportID derives a short ID for one validation run from the webhook delivery ID and head SHA
+func newReportID(deliveryID, headSHA string) string {
+    sum := sha256.Sum256([]byte(deliveryID + ":" + headSHA))
+    return hex.EncodeToString(sum[:])[:12]
+}
+
+// reportURL fills report_url in for a run, or returns "" when report_url isn't configured
+func reportURL(reportID string) string {
+    if config.ReportURL == "" {
+        return ""
+    }
+    return strings.Replace(config.ReportURL, "{report_id}", reportID, -1)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "mime"
+    "net/http"
+    "strings"
+)
+
+// webhookReport collects the webhook handler's plain-text report along with the
+// validation outcome, and writes it as text/plain or application/json
+type webhookReport struct {
+    text bytes.Buffer
+
+    ReportID    string   `json:"report_id,omitempty"`
+    PR          int      `json:"pr,omitempty"`
+    Status      string   `json:"status,omitempty"`
+    Description string   `json:"description,omitempty"`
+    Violations  []string `json:"violations,omitempty"`
+    Warnings    []string `json:"warnings,omitempty"`
+    Pending     []string `json:"pending,omitempty"`
+    Output      string   `json:"output"`
+}
+
+// Write appends to the plain-text report
+func (rep *webhookReport) Write(p []byte) (int, error) {
+    return rep.text.Write(p)
+}
+
+// send writes the report as JSON when the request asks for it and as plain text otherwise
+func (rep *webhookReport) send(w http.ResponseWriter, r *http.Request) {
+    if !wantsJSON(r) {
+        w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+        w.Write(rep.text.Bytes())
+        return
+    }
+    rep.Output = rep.text.String()
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    json.NewEncoder(w).Encode(rep)
+}
+
+// wantsJSON reports whether the request asks for JSON with ?format=json or an Accept
+// header listing application/json
+func wantsJSON(r *http.Request) bool {
+    if format := r.URL.Query().Get("format"); format != "" {
+        return format == "json"
+    }
+    for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+        mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+        if err == nil && mediaType == "application/json" {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,1334 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net"
+    "path"
+    "path/filepath"
+    "reflect"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+    "unicode/utf8"
+)
+
+// prContext is the PR under validation and everything already fetched about it.
+// Rules must treat it as read-only.
+type prContext struct {
+    Owner   string
+    Repo    string
+    Number  int
+    Action  string
+    Labels  []Label
+    Files   []PRFile
+    Details *PRDetails // nil when the PR details couldn't be fetched
+    // ReportID identifies this validation run in logs, statuses and comments
+    ReportID string
+    // GitLab is set for merge requests from the GitLab webhook, which have no GitHub
+    // diff, commits, reviews or tree to fetch
+    GitLab bool
+
+    // ChangedApps are the apps with files changed under appname/module/file paths
+    ChangedApps []string
+
+    // PRAppsJson is apps.json at the PR head, set when the PR changes apps.json
+    PRAppsJson *AppsJson
+    // BaseAppsJson is apps.json at the PR base, set when the PR changes apps.json
+    BaseAppsJson *AppsJson
+    // AppServers are the servers impacted by each app apps.json changes
+    AppServers map[string]map[string]bool
+    // BaseAppServers are the servers each app in AppServers impacted at the PR base
+    BaseAppServers map[string]map[string]bool
+    // ImpactedServers are all servers impacted by the apps.json changes
+    ImpactedServers map[string]bool
+    // ProdServers are the prod servers impacted by the apps.json changes
+    ProdServers map[string]bool
+
+    // fetchFile reads a file at a ref; nil reads it from GitHub
+    fetchFile func(ctx context.Context, path, ref string) ([]byte, error)
+
+    diffOnce sync.Once
+    diff     string
+    diffErr  error
+
+    commitsOnce sync.Once
+    commits     []Commit
+    commitsErr  error
+}
+
+// HeadRef is the ref to read the PR's proposed content at
+func (pc *prContext) HeadRef() string {
+    if pc.Details != nil && pc.Details.Head.SHA != "" {
+        return pc.Details.Head.SHA
+    }
+    return fmt.Sprintf("refs/pull/%d/head", pc.Number)
+}
+
+// FileContent reads path at ref from the repo the PR belongs to
+func (pc *prContext) FileContent(ctx context.Context, path, ref string) ([]byte, error) {
+    if pc.fetchFile != nil {
+        return pc.fetchFile(ctx, path, ref)
+    }
+    return githubAPI.FetchFileContent(ctx, pc.Owner, pc.Repo, path, ref)
+}
+
+// Diff fetches the PR's full diff on first use and shares it across rules
+func (pc *prContext) Diff(ctx context.Context) (string, error) {
+    pc.diffOnce.Do(func() {
+        if pc.GitLab {
+            pc.diffErr = errGitHubOnly
+            return
+        }
+        pc.diff, pc.diffErr = githubAPI.FetchPRDiff(ctx, pc.Owner, pc.Repo, pc.Number)
+    })
+    return pc.diff, pc.diffErr
+}
+
+// Commits fetches the PR's commits on first use and shares them across rules
+func (pc *prContext) Commits(ctx context.Context) ([]Commit, error) {
+    pc.commitsOnce.Do(func() {
+        if pc.GitLab {
+            pc.commitsErr = errGitHubOnly
+            return
+        }
+        pc.commits, pc.commitsErr = githubAPI.FetchPRCommits(ctx, pc.Owner, pc.Repo, pc.Number)
+    })
+    return pc.commits, pc.commitsErr
+}
+
+// fillOmittedPatches finds changed text files the files API sent without a patch and, when
+// omitted_patch_action is "diff", fills them in from the PR's full diff. It returns the
+// files still missing a patch.
+func fillOmittedPatches(ctx context.Context, pc *prContext) []string {
+    var omitted []int
+    for i, f := range pc.Files {
+        // Binary files have no patch to recover
+        if f.Patch == "" && f.Changes > 0 && !isBinaryFile(f) {
+            omitted = append(omitted, i)
+        }
+    }
+    if len(omitted) == 0 {
+        return nil
+    }
+    if config.OmittedPatchAction == "diff" {
+        diff, err := pc.Diff(ctx)
+        if err != nil {
+            loggerFrom(ctx).Printf("Could not fetch full diff for omitted patches: %v", err)
+        } else {
+            patches := filePatches(diff)
+            for _, i := range omitted {
+                pc.Files[i].Patch = patches[pc.Files[i].Filename]
+            }
+        }
+    }
+    var missing []string
+    for _, i := range omitted {
+        if pc.Files[i].Patch == "" {
+            missing = append(missing, pc.Files[i].Filename)
+        }
+    }
+    return missing
+}
+
+// ruleResult is the outcome of evaluating one rule
+type ruleResult struct {
+    Rule     string
+    Skipped  bool
+    // SkipReason says why a skipped rule didn't run
+    SkipReason string
+    Failures []string
+    Warnings []string
+    // Pending holds requirements not met yet, like missing approvals, that don't fail the PR
+    Pending  []string
+    Err      error
+    Duration time.Duration
+}
+
+// Rule is a named validation check. Check records failures and warnings on res
+// and returns an error only when it couldn't evaluate the PR.
+type Rule struct {
+    Name  string
+    Check func(ctx context.Context, pc *prContext, res *ruleResult) error
+    // GitHubOnly rules read GitHub reviews, commits, trees or org membership and are
+    // skipped for GitLab merge requests
+    GitHubOnly bool
+    // Configured reports whether the settings the rule checks are set; rules without
+    // it always apply
+    Configured func() bool
+}
+
+// errRuleTimeout is reported for rules that exceed their timeout
+var errRuleTimeout = errors.New("rule timed out")
+
+// errGitHubOnly is returned for GitHub data a GitLab merge request doesn't have
+var errGitHubOnly = errors.New("not available for GitLab merge requests")
+
+// rules are evaluated in order for every validated PR
+var rules = []Rule{
+    {Name: "same-repo", Check: sameRepoRule, Configured: func() bool { return config.RequireSameRepo != "" }},
+    {Name: "schema-version", Check: schemaVersionRule, Configured: func() bool { return config.SchemaVersion != "" }},
+    {Name: "prod-impact", Check: prodImpactRule, Configured: func() bool { return config.MaxProdImpact > 0 }},
+    {Name: "app-name-uniqueness", Check: appNameUniquenessRule, Configured: func() bool { return len(config.AppsFiles) >= 2 }},
+    {Name: "self-config", Check: selfConfigRule, GitHubOnly: true, Configured: func() bool { return len(config.SelfConfigPaths) > 0 }},
+    {Name: "required-app-files", Check: requiredAppFilesRule, GitHubOnly: true, Configured: func() bool { return len(config.AppRequiredFiles) > 0 }},
+    {Name: "signed-commits", Check: signedCommitsRule, GitHubOnly: true, Configured: func() bool { return config.RequireSignedCommits }},
+    {Name: "utf8", Check: utf8Rule, Configured: func() bool { return len(config.UTF8Extensions) > 0 }},
+    {Name: "linear-history", Check: linearHistoryRule, GitHubOnly: true, Configured: func() bool { return config.RequireLinearHistory }},
+    {Name: "added-files-per-extension", Check: addedFilesPerExtensionRule, Configured: func() bool { return len(config.MaxAddedFilesByExtension) > 0 }},
+    {Name: "critical-files", Check: criticalFilesRule, Configured: func() bool { return len(config.CriticalFiles) > 0 }},
+    {Name: "stale-blacklists", Check: staleBlacklistsRule, Configured: func() bool { return config.CheckStaleBlacklists }},
+    {Name: "max-commits", Check: maxCommitsRule, GitHubOnly: true, Configured: func() bool { return config.MaxCommits > 0 }},
+    {Name: "new-app-whitelist", Check: newAppWhitelistRule},
+    {Name: "file-modes", Check: fileModesRule, GitHubOnly: true, Configured: func() bool { return config.CheckFileModes != "" }},
+    {Name: "app-server-patterns", Check: appServerPatternsRule, Configured: func() bool { return len(config.appServerRes) > 0 }},
+    {Name: "app-approvals", Check: appApprovalsRule, GitHubOnly: true, Configured: func() bool { return len(config.AppRequiredApprovals) > 0 }},
+    {Name: "registered-apps", Check: registeredAppsRule, Configured: func() bool { return config.RequireRegisteredApps }},
+    {Name: "rollback-plan", Check: rollbackPlanRule, Configured: func() bool { return config.RollbackSection != "" }},
+    {Name: "cmdb-tickets", Check: cmdbTicketsRule, Configured: func() bool { return config.cmdbTicketRe != nil }},
+    {Name: "whitespace-only", Check: whitespaceOnlyRule, Configured: func() bool { return config.WhitespaceOnlyAction != "" }},
+    {Name: "app-server-count", Check: appServerCountRule, Configured: func() bool { return config.MaxAppServers > 0 }},
+    {Name: "forbidden-files", Check: forbiddenFilesRule, Configured: func() bool { return len(config.ForbiddenFiles) > 0 }},
+    {Name: "org-membership", Check: orgMembershipRule, GitHubOnly: true, Configured: func() bool { return config.NonMemberAction == "neutral" || config.NonMemberAction == "fail" }},
+    {Name: "stale-head", Check: staleHeadRule, GitHubOnly: true, Configured: func() bool { return config.MaxHeadAge.Duration > 0 || config.MaxBehindBy > 0 }},
+    {Name: "maintenance-windows", Check: maintenanceWindowsRule, Configured: func() bool { return maintenanceSource != nil }},
+    {Name: "lockfile-manifests", Check: lockfileManifestsRule, Configured: func() bool { return len(config.LockfileManifests) > 0 }},
+    {Name: "app-renames", Check: appRenamesRule, GitHubOnly: true},
+    {Name: "impact-approvals", Check: impactApprovalsRule, GitHubOnly: true, Configured: func() bool { return config.ImpactApprovalThreshold > 0 }},
+    {Name: "apps-json-hygiene", Check: appsJsonHygieneRule, Configured: func() bool { return config.AppsJsonHygiene != "" }},
+    {Name: "module-cohesion", Check: moduleCohesionRule, Configured: func() bool { return config.MaxModulesPerApp > 0 }},
+    {Name: "whitelist-dns", Check: whitelistDNSRule, Configured: func() bool { return config.DNSCheckAction != "" }},
+    {Name: "app-forbidden-bases", Check: appForbiddenBasesRule, Configured: func() bool { return len(config.AppForbiddenBases) > 0 }},
+    {Name: "overlapping-prs", Check: overlappingPRsRule, Configured: func() bool { return config.CheckOverlappingPRs }},
+    {Name: "removed-servers", Check: removedServersRule, Configured: func() bool { return config.CheckRemovedServers }},
+    {Name: "list-conflicts", Check: listConflictsRule},
+}
+
+// ruleByName looks up a rule in the registry
+func ruleByName(name string) (Rule, bool) {
+    for _, rule := range rules {
+        if rule.Name == name {
+            return rule, true
+        }
+    }
+    return Rule{}, false
+}
+
+// selectRules picks the rules to run for a PR: the profile mapped from its first
+// label found in label_profiles, else default_profile, else every rule. It returns
+// the rules and the profile name ("" for every rule).
+func selectRules(labels []Label) ([]Rule, string) {
+    profile := config.DefaultProfile
+    for _, l := range labels {
+        if p, ok := config.LabelProfiles[l.Name]; ok {
+            profile = p
+            break
+        }
+    }
+    if profile == "" {
+        return rules, ""
+    }
+    var selected []Rule
+    for _, name := range config.Profiles[profile] {
+        if rule, ok := ruleByName(name); ok {
+            selected = append(selected, rule)
+        }
+    }
+    return selected, profile
+}
+
+// ruleTimeout returns the configured timeout for a rule, falling back to the default
+func ruleTimeout(name string) time.Duration {
+    if d, ok := config.RuleTimeouts[name]; ok && d.Duration > 0 {
+        return d.Duration
+    }
+    return config.RuleTimeout.Duration
+}
+
+// runRule evaluates one rule, giving up once its timeout expires
+func runRule(ctx context.Context, rule Rule, pc *prContext) ruleResult {
+    ctx, cancel := context.WithTimeout(ctx, ruleTimeout(rule.Name))
+    defer cancel()
+    start := time.Now()
+    done := make(chan ruleResult, 1)
+    go func() {
+        res := ruleResult{Rule: rule.Name}
+        res.Err = rule.Check(ctx, pc, &res)
+        done <- res
+    }()
+    select {
+    case res := <-done:
+        res.Duration = time.Since(start)
+        return res
+    case <-ctx.Done():
+        return ruleResult{Rule: rule.Name, Err: errRuleTimeout, Duration: time.Since(start)}
+    }
+}
+
+// ruleEnabled reports whether a rule is enabled; rules are enabled unless configured otherwise
+func ruleEnabled(name string) bool {
+    s, ok := config.Rules[name]
+    return !ok || s.Enabled == nil || *s.Enabled
+}
+
+// runRules evaluates rules in order, skipping disabled and unconfigured rules and GitHub-only
+// rules for GitLab, and continuing past rules that error or time out
+func runRules(ctx context.Context, pc *prContext, rules []Rule) []ruleResult {
+    var results []ruleResult
+    for _, rule := range rules {
+        if !ruleEnabled(rule.Name) {
+            results = append(results, ruleResult{Rule: rule.Name, Skipped: true, SkipReason: "disabled"})
+            continue
+        }
+        if rule.GitHubOnly && pc.GitLab {
+            results = append(results, ruleResult{Rule: rule.Name, Skipped: true, SkipReason: "not supported for GitLab"})
+            continue
+        }
+        if rule.Configured != nil && !rule.Configured() {
+            results = append(results, ruleResult{Rule: rule.Name, Skipped: true, SkipReason: "not configured"})
+            continue
+        }
+        res := runRule(ctx, rule, pc)
+        if res.Err != nil {
+            loggerFrom(ctx).Printf("Rule %s did not complete after %s: %v", rule.Name, res.Duration, res.Err)
+        }
+        results = append(results, res)
+    }
+    return results
+}
+
+// sameRepoRule flags PRs from another owner's repo when require_same_repo is set
+func sameRepoRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    if pc.Details == nil {
+        return nil
+    }
+    if msg := checkSameRepo(pc.Details); msg != "" {
+        if config.RequireSameRepo == "fail" {
+            res.Failures = append(res.Failures, msg)
+        } else {
+            res.Warnings = append(res.Warnings, msg)
+        }
+    }
+    return nil
+}
+
+// schemaVersionRule checks the proposed apps.json declares the configured schema_version
+func schemaVersionRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    if pc.PRAppsJson == nil {
+        return nil
+    }
+    if v := checkSchemaVersion(*pc.PRAppsJson, config.SchemaVersion); v != "" {
+        res.Failures = append(res.Failures, v)
+    }
+    return nil
+}
+
+// prodImpactRule enforces max_prod_impact on the prod servers impacted by apps.json changes
+func prodImpactRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    if v := checkProdImpact(pc.ProdServers, pc.Labels); v != "" {
+        res.Failures = append(res.Failures, v)
+    }
+    return nil
+}
+
+// appNameUniquenessRule checks app names are unique across all configured app-config files
+func appNameUniquenessRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    appsFileChanged := false
+    for _, f := range pc.Files {
+        for _, path := range config.AppsFiles {
+            if f.Filename == path {
+                appsFileChanged = true
+            }
+        }
+    }
+    if !appsFileChanged {
+        return nil
+    }
+    appsFiles := make(map[string]AppsJson)
+    for _, path := range config.AppsFiles {
+        data, err := pc.FileContent(ctx, path, pc.HeadRef())
+        if isNotFound(err) {
+            // An apps file the PR deletes, or that doesn't exist yet, lists no apps
+            continue
+        }
+        if err != nil {
+            return fmt.Errorf("fetching %s at PR head: %w", path, err)
+        }
+        var appsJson AppsJson
+        if err := json.Unmarshal(data, &appsJson); err != nil {
+            res.Failures = append(res.Failures, fmt.Sprintf("%s is not valid JSON: %v", path, err))
+            continue
+        }
+        appsFiles[path] = appsJson
+    }
+    res.Failures = append(res.Failures, checkAppNameUniqueness(appsFiles)...)
+    return nil
+}
+
+// matchesAny reports whether name equals or glob-matches any of patterns
+func matchesAny(name string, patterns []string) bool {
+    for _, p := range patterns {
+        if p == name {
+            return true
+        }
+        if ok, _ := path.Match(p, name); ok {
+            return true
+        }
+    }
+    return false
+}
+
+// selfConfigRule requires an approval from a self_config_approvers entry when the PR
+// changes the validator's own config. Approvers are logins or "org/team" slugs.
+func selfConfigRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    var changed []string
+    for _, f := range pc.Files {
+        if matchesAny(f.Filename, config.SelfConfigPaths) || (f.PreviousFilename != "" && matchesAny(f.PreviousFilename, config.SelfConfigPaths)) {
+            changed = append(changed, f.Filename)
+        }
+    }
+    if len(changed) == 0 {
+        return nil
+    }
+    reviews, err := githubAPI.FetchPRReviews(ctx, pc.Owner, pc.Repo, pc.Number)
+    if err != nil {
+        return err
+    }
+    for _, login := range approvedReviewers(reviews) {
+        for _, approver := range config.SelfConfigApprovers {
+            org, team, isTeam := strings.Cut(approver, "/")
+            if !isTeam {
+                if strings.EqualFold(approver, login) {
+                    res.Warnings = append(res.Warnings, fmt.Sprintf("protected validator config changed (%s), approved by %s", strings.Join(changed, ", "), login))
+                    return nil
+                }
+                continue
+            }
+            member, err := githubAPI.IsTeamMember(ctx, org, team, login)
+            if err != nil {
+                return err
+            }
+            if member {
+                res.Warnings = append(res.Warnings, fmt.Sprintf("protected validator config changed (%s), approved by %s of %s", strings.Join(changed, ", "), login, approver))
+                return nil
+            }
+        }
+    }
+    res.Failures = append(res.Failures, fmt.Sprintf("PR changes protected validator config (%s) and needs approval from one of: %s", strings.Join(changed, ", "), strings.Join(config.SelfConfigApprovers, ", ")))
+    return nil
+}
+
+// requiredAppFilesRule checks that apps created by the PR contain the files app_required_files
+// lists for them (or for "*"), using the repo trees at the base and head commits
+func requiredAppFilesRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    if len(pc.ChangedApps) == 0 {
+        return nil
+    }
+    if pc.Details == nil {
+        return errors.New("PR details unavailable")
+    }
+    baseTree, err := githubAPI.FetchTree(ctx, pc.Owner, pc.Repo, pc.Details.Base.SHA)
+    if err != nil {
+        return err
+    }
+    var headTree map[string]bool
+    for _, app := range pc.ChangedApps {
+        required := append(append([]string{}, config.AppRequiredFiles["*"]...), config.AppRequiredFiles[app]...)
+        if len(required) == 0 || treeHasDir(baseTree, app) {
+            continue
+        }
+        if headTree == nil {
+            if headTree, err = githubAPI.FetchTree(ctx, pc.Owner, pc.Repo, pc.Details.Head.SHA); err != nil {
+                return err
+            }
+        }
+        for _, file := range required {
+            if !headTree[app+"/"+file] {
+                res.Failures = append(res.Failures, fmt.Sprintf("new app %s is missing required file %s/%s", app, app, file))
+            }
+        }
+    }
+    return nil
+}
+
+// treeHasDir reports whether any path in tree lives under dir
+func treeHasDir(tree map[string]bool, dir string) bool {
+    for p := range tree {
+        if strings.HasPrefix(p, dir+"/") {
+            return true
+        }
+    }
+    return false
+}
+
+// signedCommitsRule fails PRs containing commits GitHub couldn't verify a signature for
+func signedCommitsRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    commits, err := pc.Commits(ctx)
+    if err != nil {
+        return err
+    }
+    var unverified []string
+    for _, c := range commits {
+        if !c.Commit.Verification.Verified {
+            unverified = append(unverified, fmt.Sprintf("%.7s (%s)", c.SHA, c.Commit.Verification.Reason))
+        }
+    }
+    if len(unverified) > 0 {
+        res.Failures = append(res.Failures, fmt.Sprintf("commits without a verified signature: %s", strings.Join(unverified, ", ")))
+    }
+    return nil
+}
+
+// utf8Rule fails files with a utf8_extensions extension whose content at the PR head isn't valid UTF-8
+func utf8Rule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    for _, f := range pc.Files {
+        if f.Status == "removed" || !containsFold(config.UTF8Extensions, filepath.Ext(f.Filename)) {
+            continue
+        }
+        content, err := pc.FileContent(ctx, f.Filename, pc.HeadRef())
+        if err != nil {
+            return err
+        }
+        if !utf8.Valid(content) {
+            res.Failures = append(res.Failures, fmt.Sprintf("%s is not valid UTF-8", f.Filename))
+        }
+    }
+    return nil
+}
+
+// linearHistoryRule fails PRs containing merge commits when require_linear_history is set
+func linearHistoryRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    commits, err := pc.Commits(ctx)
+    if err != nil {
+        return err
+    }
+    var merges []string
+    for _, c := range commits {
+        if len(c.Parents) > 1 {
+            merges = append(merges, fmt.Sprintf("%.7s", c.SHA))
+        }
+    }
+    if len(merges) > 0 {
+        res.Failures = append(res.Failures, fmt.Sprintf("PR contains merge commits, rebase instead: %s", strings.Join(merges, ", ")))
+    }
+    return nil
+}
+
+// addedFilesPerExtensionRule caps how many files with each extension a PR may add
+func addedFilesPerExtensionRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    counts := make(map[string]int)
+    for _, f := range pc.Files {
+        if f.Status != "added" {
+            continue
+        }
+        ext := strings.ToLower(filepath.Ext(f.Filename))
+        if _, limited := config.MaxAddedFilesByExtension[ext]; limited {
+            counts[ext]++
+        }
+    }
+    var exts []string
+    for ext := range counts {
+        exts = append(exts, ext)
+    }
+    sort.Strings(exts)
+    for _, ext := range exts {
+        if limit := config.MaxAddedFilesByExtension[ext]; counts[ext] > limit {
+            res.Failures = append(res.Failures, fmt.Sprintf("PR adds %d %s files, limit is %d", counts[ext], ext, limit))
+        }
+    }
+    return nil
+}
+
+// criticalFilesRule blocks PRs that remove or rename a critical_files governance file
+func criticalFilesRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    for _, f := range pc.Files {
+        switch {
+        case f.Status == "removed" && matchesAny(f.Filename, config.CriticalFiles):
+            res.Failures = append(res.Failures, fmt.Sprintf("BLOCKED: critical file %s is deleted by this PR", f.Filename))
+        case f.Status == "renamed" && matchesAny(f.PreviousFilename, config.CriticalFiles):
+            res.Failures = append(res.Failures, fmt.Sprintf("BLOCKED: critical file %s is renamed to %s by this PR", f.PreviousFilename, f.Filename))
+        }
+    }
+    return nil
+}
+
+// staleBlacklistsRule warns about blacklist entries that no app in apps.json whitelists,
+// which usually point at decommissioned servers
+func staleBlacklistsRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    if pc.PRAppsJson == nil {
+        return nil
+    }
+    whitelisted := make(map[string]bool)
+    for _, app := range pc.PRAppsJson.Apps {
+        for _, s := range app.Whitelists {
+            whitelisted[s] = true
+        }
+        for _, m := range app.CMDBWhitelists {
+            servers, err := resolveCMDBEntry(ctx, m)
+            if err != nil {
+                return err
+            }
+            for _, s := range servers {
+                whitelisted[s] = true
+            }
+        }
+    }
+    for _, app := range pc.PRAppsJson.Apps {
+        blacklisted := append([]string{}, app.Blacklists...)
+        for _, m := range app.CMDBBlacklists {
+            servers, err := resolveCMDBEntry(ctx, m)
+            if err != nil {
+                return err
+            }
+            blacklisted = append(blacklisted, servers...)
+        }
+        for _, s := range blacklisted {
+            if !whitelisted[s] {
+                res.Warnings = append(res.Warnings, fmt.Sprintf("app %s blacklists %s, which no app whitelists", app.Name, s))
+            }
+        }
+    }
+    return nil
+}
+
+// maxCommitsRule flags PRs with more than max_commits commits, failing or warning per max_commits_action
+func maxCommitsRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    commits, err := pc.Commits(ctx)
+    if err != nil {
+        return err
+    }
+    if len(commits) <= config.MaxCommits {
+        return nil
+    }
+    msg := fmt.Sprintf("PR has %d commits, limit is %d; consider squashing", len(commits), config.MaxCommits)
+    if config.MaxCommitsAction == "warn" {
+        res.Warnings = append(res.Warnings, msg)
+    } else {
+        res.Failures = append(res.Failures, msg)
+    }
+    return nil
+}
+
+// newAppWhitelistRule fails apps added to apps.json without any whitelist or cmdb_whitelist,
+// since they would impact no servers
+func newAppWhitelistRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    if pc.PRAppsJson == nil || pc.BaseAppsJson == nil {
+        return nil
+    }
+    existing := make(map[string]bool)
+    for _, app := range pc.BaseAppsJson.Apps {
+        existing[app.Name] = true
+    }
+    for _, app := range pc.PRAppsJson.Apps {
+        if !existing[app.Name] && len(app.Whitelists) == 0 && len(app.CMDBWhitelists) == 0 {
+            res.Failures = append(res.Failures, fmt.Sprintf("new app %s has no whitelists or cmdb_whitelists", app.Name))
+        }
+    }
+    return nil
+}
+
+// fileModesRule flags files the PR makes executable, warning or failing per check_file_modes
+func fileModesRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    diff, err := pc.Diff(ctx)
+    if err != nil {
+        return err
+    }
+    for _, c := range parseModeChanges(diff) {
+        if !c.gainedExecutable() {
+            continue
+        }
+        msg := fmt.Sprintf("%s became executable (mode %s -> %s)", c.Filename, c.OldMode, c.NewMode)
+        if c.OldMode == "" {
+            msg = fmt.Sprintf("%s was added as executable (mode %s)", c.Filename, c.NewMode)
+        }
+        if config.CheckFileModes == "fail" {
+            res.Failures = append(res.Failures, msg)
+        } else {
+            res.Warnings = append(res.Warnings, msg)
+        }
+    }
+    return nil
+}
+
+// appServerPatternsRule fails modified apps that whitelist a server outside the
+// app_server_patterns configured for that app
+func appServerPatternsRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    if pc.PRAppsJson == nil {
+        return nil
+    }
+    for _, app := range modifiedApps(pc.PRAppsJson, pc.BaseAppsJson) {
+        patterns, ok := config.appServerRes[app.Name]
+        if !ok {
+            continue
+        }
+        servers, err := whitelistedServers(ctx, app)
+        if err != nil {
+            return err
+        }
+        for _, server := range servers {
+            allowed := false
+            for _, re := range patterns {
+                if re.MatchString(server) {
+                    allowed = true
+                    break
+                }
+            }
+            if !allowed {
+                res.Failures = append(res.Failures, fmt.Sprintf("app %s whitelists %s, which is outside its allowed server patterns", app.Name, server))
+            }
+        }
+    }
+    return nil
+}
+
+// appApprovalsRule keeps the PR pending until it has the approvals app_required_approvals
+// asks for the apps it touches, counting approvals from anyone but the author
+func appApprovalsRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    apps := append([]string{}, pc.ChangedApps...)
+    if pc.PRAppsJson != nil {
+        for _, app := range modifiedApps(pc.PRAppsJson, pc.BaseAppsJson) {
+            apps = append(apps, app.Name)
+        }
+    }
+    required, requiredBy := 0, ""
+    for _, app := range apps {
+        if n := config.AppRequiredApprovals[app]; n > required {
+            required, requiredBy = n, app
+        }
+    }
+    if required == 0 {
+        return nil
+    }
+    approvals, err := countApprovals(ctx, pc)
+    if err != nil {
+        return err
+    }
+    if approvals < required {
+        res.Pending = append(res.Pending, fmt.Sprintf("waiting for %d more approval(s), %s requires %d", required-approvals, requiredBy, required))
+    }
+    return nil
+}
+
+// headAppsJson returns apps.json at the PR head, fetching it when the PR doesn't change it.
+// It returns nil when the repo has no apps.json.
+func headAppsJson(ctx context.Context, pc *prContext) (*AppsJson, error) {
+    if pc.PRAppsJson != nil {
+        return pc.PRAppsJson, nil
+    }
+    data, err := pc.FileContent(ctx, "apps.json", pc.HeadRef())
+    if isNotFound(err) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    appsJson := &AppsJson{}
+    if err := json.Unmarshal(data, appsJson); err != nil {
+        return nil, fmt.Errorf("parsing apps.json: %v", err)
+    }
+    return appsJson, nil
+}
+
+// countApprovals counts the PR's current approvals from anyone but its author
+func countApprovals(ctx context.Context, pc *prContext) (int, error) {
+    reviews, err := githubAPI.FetchPRReviews(ctx, pc.Owner, pc.Repo, pc.Number)
+    if err != nil {
+        return 0, err
+    }
+    approvals := 0
+    for _, login := range approvedReviewers(reviews) {
+        if pc.Details == nil || !strings.EqualFold(login, pc.Details.User.Login) {
+            approvals++
+        }
+    }
+    return approvals, nil
+}
+
+// registeredAppsRule fails changed files whose app isn't listed in apps.json at the PR head
+func registeredAppsRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    if len(pc.ChangedApps) == 0 {
+        return nil
+    }
+    appsJson, err := headAppsJson(ctx, pc)
+    if err != nil || appsJson == nil {
+        return err
+    }
+    registered := make(map[string]bool)
+    for _, app := range appsJson.Apps {
+        registered[app.Name] = true
+    }
+    for _, app := range pc.ChangedApps {
+        if !registered[app] {
+            res.Failures = append(res.Failures, fmt.Sprintf("files changed under %s/, but app %s is not registered in apps.json", app, app))
+        }
+    }
+    return nil
+}
+
+// rollbackPlanRule fails prod-impacting PRs whose body lacks a non-empty rollback_section
+func rollbackPlanRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    if len(pc.ProdServers) == 0 || pc.Details == nil {
+        return nil
+    }
+    if sectionContent(pc.Details.Body, config.RollbackSection) == "" {
+        res.Failures = append(res.Failures, fmt.Sprintf("PR impacts %d prod server(s) but its description has no %q section", len(pc.ProdServers), config.RollbackSection))
+    }
+    return nil
+}
+
+// sectionContent returns the trimmed text between a heading line matching heading
+// (case-insensitively) and the next markdown heading, or "" when there is none
+func sectionContent(body, heading string) string {
+    var content []string
+    inSection := false
+    for _, line := range strings.Split(strings.Replace(body, "\r\n", "\n", -1), "\n") {
+        trimmed := strings.TrimSpace(line)
+        if inSection {
+            if strings.HasPrefix(trimmed, "#") {
+                break
+            }
+            content = append(content, line)
+        } else if strings.EqualFold(trimmed, strings.TrimSpace(heading)) {
+            inSection = true
+        }
+    }
+    return strings.TrimSpace(strings.Join(content, "\n"))
+}
+
+// cmdbTicketsRule fails PRs that change an app's cmdb_whitelists or cmdb_blacklists
+// without a cmdb_ticket_pattern match in the description
+func cmdbTicketsRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    if pc.PRAppsJson == nil {
+        return nil
+    }
+    body := ""
+    if pc.Details != nil {
+        body = pc.Details.Body
+    }
+    if config.cmdbTicketRe.MatchString(body) {
+        return nil
+    }
+    for _, section := range cmdbChanges(pc.PRAppsJson, pc.BaseAppsJson) {
+        res.Failures = append(res.Failures, fmt.Sprintf("%s changed without a ticket reference matching %s in the PR description", section, config.CMDBTicketPattern))
+    }
+    return nil
+}
+
+// cmdbChanges lists the "app X cmdb_whitelists"/"app X cmdb_blacklists" sections that differ
+// between base and pr, including those of added and removed apps
+func cmdbChanges(pr, base *AppsJson) []string {
+    type cmdbSections struct {
+        whitelists, blacklists []map[string]string
+    }
+    sections := make(map[string][2]cmdbSections)
+    for i, aj := range []*AppsJson{base, pr} {
+        if aj == nil {
+            continue
+        }
+        for _, app := range aj.Apps {
+            s := sections[app.Name]
+            s[i] = cmdbSections{app.CMDBWhitelists, app.CMDBBlacklists}
+            sections[app.Name] = s
+        }
+    }
+    names := make([]string, 0, len(sections))
+    for name := range sections {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    var changed []string
+    for _, name := range names {
+        s := sections[name]
+        if !cmdbEntriesEqual(s[0].whitelists, s[1].whitelists) {
+            changed = append(changed, fmt.Sprintf("app %s cmdb_whitelists", name))
+        }
+        if !cmdbEntriesEqual(s[0].blacklists, s[1].blacklists) {
+            changed = append(changed, fmt.Sprintf("app %s cmdb_blacklists", name))
+        }
+    }
+    return changed
+}
+
+// cmdbEntriesEqual compares cmdb_* lists, treating missing and empty lists alike
+func cmdbEntriesEqual(a, b []map[string]string) bool {
+    if len(a) == 0 && len(b) == 0 {
+        return true
+    }
+    return reflect.DeepEqual(a, b)
+}
+
+// whitespaceOnlyRule flags PRs where every file's patch only changes whitespace, warning
+// or failing per whitespace_only_action. Files without a patch, like binaries, count as real changes.
+func whitespaceOnlyRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    for _, f := range pc.Files {
+        if f.Patch == "" || !whitespaceOnly(f.Patch) {
+            return nil
+        }
+    }
+    msg := fmt.Sprintf("PR only changes whitespace in its %d file(s)", len(pc.Files))
+    if config.WhitespaceOnlyAction == "fail" {
+        res.Failures = append(res.Failures, msg)
+    } else {
+        res.Warnings = append(res.Warnings, msg)
+    }
+    return nil
+}
+
+// appServerCountRule fails modified apps that whitelist more than max_app_servers servers
+func appServerCountRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    if pc.PRAppsJson == nil {
+        return nil
+    }
+    for _, app := range modifiedApps(pc.PRAppsJson, pc.BaseAppsJson) {
+        servers, err := whitelistedServers(ctx, app)
+        if err != nil {
+            return err
+        }
+        unique := make(map[string]bool)
+        for _, s := range servers {
+            unique[s] = true
+        }
+        if len(unique) > config.MaxAppServers {
+            res.Failures = append(res.Failures, fmt.Sprintf("app %s whitelists %d servers, limit is %d", app.Name, len(unique), config.MaxAppServers))
+        }
+    }
+    return nil
+}
+
+// forbiddenFilesRule fails PRs that add or change forbidden_files. On GitHub, added files are
+// checked against the base branch history so resurrecting a removed file is reported as such.
+func forbiddenFilesRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    for _, f := range pc.Files {
+        if f.Status == "removed" || !(matchesAny(f.Filename, config.ForbiddenFiles) || matchesAny(path.Base(f.Filename), config.ForbiddenFiles)) {
+            continue
+        }
+        if f.Status != "added" && f.Status != "renamed" && f.Status != "copied" {
+            res.Failures = append(res.Failures, fmt.Sprintf("forbidden file %s already exists and is changed; remove it instead", f.Filename))
+            continue
+        }
+        existed := false
+        if pc.Details != nil && !pc.GitLab {
+            var err error
+            if existed, err = githubAPI.PathHasHistory(ctx, pc.Owner, pc.Repo, f.Filename, pc.Details.Base.Ref); err != nil {
+                return err
+            }
+        }
+        if existed {
+            res.Failures = append(res.Failures, fmt.Sprintf("PR re-adds forbidden file %s, which was previously removed", f.Filename))
+        } else {
+            res.Failures = append(res.Failures, fmt.Sprintf("PR adds forbidden file %s", f.Filename))
+        }
+    }
+    return nil
+}
+
+// orgMembershipRule reports PR authors who aren't members of the repo's org, as a warning
+// or failure per non_member_action. "skip" is handled before rules run.
+func orgMembershipRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    if pc.Details == nil {
+        return nil
+    }
+    author := pc.Details.User.Login
+    member, err := githubAPI.IsOrgMember(ctx, pc.Owner, author)
+    if errors.Is(err, errNotOrg) {
+        // A user-owned repo has no org to be a member of
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    if member {
+        return nil
+    }
+    msg := fmt.Sprintf("PR author %s is not a member of %s", author, pc.Owner)
+    if config.NonMemberAction == "fail" {
+        res.Failures = append(res.Failures, msg)
+    } else {
+        res.Warnings = append(res.Warnings, msg)
+    }
+    return nil
+}
+
+// staleHeadRule asks for a rebase when the PR head commit is older than max_head_age or
+// more than max_behind_by commits behind its base, failing or warning per stale_head_action
+func staleHeadRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    if pc.Details == nil {
+        return nil
+    }
+    var stale []string
+    if config.MaxHeadAge.Duration > 0 {
+        commits, err := pc.Commits(ctx)
+        if err != nil {
+            return err
+        }
+        if len(commits) > 0 {
+            // A rebase keeps the author date but resets the committer date
+            age := time.Since(commits[len(commits)-1].Commit.Committer.Date)
+            if age > config.MaxHeadAge.Duration {
+                stale = append(stale, fmt.Sprintf("head commit is %s old, limit is %s", age.Round(time.Hour), config.MaxHeadAge))
+            }
+        }
+    }
+    if config.MaxBehindBy > 0 {
+        cmp, err := githubAPI.CompareCommits(ctx, pc.Owner, pc.Repo, pc.Details.Base.Ref, pc.Details.Head.SHA)
+        if err != nil {
+            return err
+        }
+        if cmp.BehindBy > config.MaxBehindBy {
+            stale = append(stale, fmt.Sprintf("head is %d commits behind %s, limit is %d", cmp.BehindBy, pc.Details.Base.Ref, config.MaxBehindBy))
+        }
+    }
+    for _, s := range stale {
+        msg := s + "; please rebase"
+        if config.StaleHeadAction == "warn" {
+            res.Warnings = append(res.Warnings, msg)
+        } else {
+            res.Failures = append(res.Failures, msg)
+        }
+    }
+    return nil
+}
+
+// maintenanceWindowsRule warns about impacted prod servers with no maintenance window
+// active now or starting within maintenance_lookahead
+func maintenanceWindowsRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    now := time.Now()
+    for _, server := range sortedKeys(pc.ProdServers) {
+        windows, err := maintenanceSource.Windows(ctx, server)
+        if err != nil {
+            return err
+        }
+        if !coveredByWindow(windows, now, config.MaintenanceLookahead.Duration) {
+            res.Warnings = append(res.Warnings, fmt.Sprintf("prod server %s has no maintenance window in the next %s", server, config.MaintenanceLookahead))
+        }
+    }
+    return nil
+}
+
+// lockfileManifestsRule fails lockfiles changed without their lockfile_manifests manifest
+// changing alongside them
+func lockfileManifestsRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    changed := make(map[string]bool)
+    for _, f := range pc.Files {
+        changed[f.Filename] = true
+    }
+    for _, f := range pc.Files {
+        manifest, ok := config.LockfileManifests[path.Base(f.Filename)]
+        if !ok || f.Status == "removed" {
+            continue
+        }
+        manifestPath := path.Join(path.Dir(f.Filename), manifest)
+        if !changed[manifestPath] {
+            res.Failures = append(res.Failures, fmt.Sprintf("%s changed without %s", f.Filename, manifestPath))
+        }
+    }
+    return nil
+}
+
+// appRenamesRule fails PRs that move every file out of one app directory into another
+// unless apps.json drops the old app and lists the new one
+func appRenamesRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    renames := make(map[string]string)
+    var order []string
+    for _, f := range pc.Files {
+        if f.Status != "renamed" || f.PreviousFilename == "" {
+            continue
+        }
+        oldParts, newParts := strings.Split(f.PreviousFilename, "/"), strings.Split(f.Filename, "/")
+        if len(oldParts) < 3 || len(newParts) < 3 || oldParts[0] == newParts[0] {
+            continue
+        }
+        if _, seen := renames[oldParts[0]]; !seen {
+            order = append(order, oldParts[0])
+        }
+        renames[oldParts[0]] = newParts[0]
+    }
+    if len(renames) == 0 {
+        return nil
+    }
+    // Moving some of an app's files elsewhere isn't a rename; the old app directory
+    // must be empty at head
+    if pc.Details != nil {
+        headTree, err := githubAPI.FetchTree(ctx, pc.Owner, pc.Repo, pc.Details.Head.SHA)
+        if err != nil {
+            return err
+        }
+        for path := range headTree {
+            if app, _, ok := strings.Cut(path, "/"); ok {
+                delete(renames, app)
+            }
+        }
+        if len(renames) == 0 {
+            return nil
+        }
+    }
+    appsJson, err := headAppsJson(ctx, pc)
+    if err != nil || appsJson == nil {
+        return err
+    }
+    listed := make(map[string]bool)
+    for _, app := range appsJson.Apps {
+        listed[app.Name] = true
+    }
+    for _, oldApp := range order {
+        newApp, ok := renames[oldApp]
+        if !ok {
+            continue
+        }
+        if listed[oldApp] {
+            res.Failures = append(res.Failures, fmt.Sprintf("files moved from %s/ to %s/, but apps.json still lists %s", oldApp, newApp, oldApp))
+        }
+        if !listed[newApp] {
+            res.Failures = append(res.Failures, fmt.Sprintf("files moved from %s/ to %s/, but apps.json does not list %s", oldApp, newApp, newApp))
+        }
+    }
+    return nil
+}
+
+// impactApprovalsRule keeps PRs impacting more than impact_approval_threshold servers
+// pending until they have impact_required_approvals approvals
+func impactApprovalsRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    if len(pc.ImpactedServers) <= config.ImpactApprovalThreshold {
+        return nil
+    }
+    approvals, err := countApprovals(ctx, pc)
+    if err != nil {
+        return err
+    }
+    if approvals < config.ImpactRequiredApprovals {
+        res.Pending = append(res.Pending, fmt.Sprintf("PR impacts %d servers (over %d), waiting for %d more approval(s) of %d required",
+            len(pc.ImpactedServers), config.ImpactApprovalThreshold, config.ImpactRequiredApprovals-approvals, config.ImpactRequiredApprovals))
+    }
+    return nil
+}
+
+// appsJsonHygieneRule fails app names and server entries of modified apps that have
+// surrounding whitespace or, per apps_json_hygiene, the wrong casing
+func appsJsonHygieneRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    if pc.PRAppsJson == nil {
+        return nil
+    }
+    check := func(app, what, value string) {
+        if strings.TrimSpace(value) != value {
+            res.Failures = append(res.Failures, fmt.Sprintf("app %s %s %q has leading or trailing whitespace", app, what, value))
+        }
+        if config.AppsJsonHygiene == "lower" && strings.ToLower(value) != value {
+            res.Failures = append(res.Failures, fmt.Sprintf("app %s %s %q is not lowercase", app, what, value))
+        }
+        if config.AppsJsonHygiene == "upper" && strings.ToUpper(value) != value {
+            res.Failures = append(res.Failures, fmt.Sprintf("app %s %s %q is not uppercase", app, what, value))
+        }
+    }
+    for _, app := range modifiedApps(pc.PRAppsJson, pc.BaseAppsJson) {
+        check(app.Name, "name", app.Name)
+        for _, s := range app.Whitelists {
+            check(app.Name, "whitelist entry", s)
+        }
+        for _, s := range app.Blacklists {
+            check(app.Name, "blacklist entry", s)
+        }
+        for _, entries := range [][]map[string]string{app.CMDBWhitelists, app.CMDBBlacklists} {
+            for _, m := range entries {
+                keys := make([]string, 0, len(m))
+                for k := range m {
+                    keys = append(keys, k)
+                }
+                sort.Strings(keys)
+                for _, k := range keys {
+                    check(app.Name, "cmdb entry", m[k])
+                }
+            }
+        }
+    }
+    return nil
+}
+
+// moduleCohesionRule fails PRs changing more than max_modules_per_app modules of one app
+// unless the PR carries the multi_module_ack label or mentions it in its description
+func moduleCohesionRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    if config.MultiModuleAck != "" {
+        if hasLabel(pc.Labels, config.MultiModuleAck) || (pc.Details != nil && strings.Contains(pc.Details.Body, config.MultiModuleAck)) {
+            return nil
+        }
+    }
+    modules := make(map[string]map[string]bool)
+    for _, f := range pc.Files {
+        app, module, _, ok := splitAppPath(f.Filename)
+        if !ok {
+            continue
+        }
+        if modules[app] == nil {
+            modules[app] = make(map[string]bool)
+        }
+        modules[app][module] = true
+    }
+    for _, app := range pc.ChangedApps {
+        if len(modules[app]) > config.MaxModulesPerApp {
+            msg := fmt.Sprintf("PR changes %d modules of %s (%s), limit is %d", len(modules[app]), app, strings.Join(sortedKeys(modules[app]), ", "), config.MaxModulesPerApp)
+            if config.MultiModuleAck != "" {
+                msg += fmt.Sprintf("; acknowledge the scope with %q", config.MultiModuleAck)
+            }
+            res.Failures = append(res.Failures, msg)
+        }
+    }
+    return nil
+}
+
+// whitelistDNSRule flags servers newly added to whitelists that don't resolve, warning or
+// failing per dns_check_action
+func whitelistDNSRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    if pc.PRAppsJson == nil {
+        return nil
+    }
+    resolver := net.DefaultResolver
+    if config.DNSResolver != "" {
+        resolver = &net.Resolver{
+            PreferGo: true,
+            Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+                var d net.Dialer
+                return d.DialContext(ctx, network, config.DNSResolver)
+            },
+        }
+    }
+    added := addedWhitelistEntries(pc.PRAppsJson, pc.BaseAppsJson)
+    apps := make([]string, 0, len(added))
+    for app := range added {
+        apps = append(apps, app)
+    }
+    sort.Strings(apps)
+    for _, app := range apps {
+        for _, server := range added[app] {
+            lookupCtx, cancel := context.WithTimeout(ctx, config.DNSTimeout.Duration)
+            _, err := resolver.LookupHost(lookupCtx, server)
+            cancel()
+            if err == nil {
+                continue
+            }
+            msg := fmt.Sprintf("app %s whitelists %s, which does not resolve: %v", app, server, err)
+            if config.DNSCheckAction == "fail" {
+                res.Failures = append(res.Failures, msg)
+            } else {
+                res.Warnings = append(res.Warnings, msg)
+            }
+        }
+    }
+    return nil
+}
+
+// appForbiddenBasesRule fails PRs changing an app's files on a base branch app_forbidden_bases
+// forbids for that app
+func appForbiddenBasesRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    if pc.Details == nil {
+        return nil
+    }
+    base := pc.Details.Base.Ref
+    for _, app := range pc.ChangedApps {
+        if matchesAny(base, config.AppForbiddenBases[app]) {
+            res.Failures = append(res.Failures, fmt.Sprintf("app %s may not be changed directly on %s", app, base))
+        }
+    }
+    return nil
+}
+
+// overlappingPRsRule warns about other open PRs, as last validated, that impact some of
+// the same servers
+func overlappingPRsRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    if len(pc.ImpactedServers) == 0 {
+        return nil
+    }
+    overlaps := overlappingPRs(prKey(pc.Owner, pc.Repo, pc.Number), pc.ImpactedServers)
+    others := make([]string, 0, len(overlaps))
+    for other := range overlaps {
+        others = append(others, other)
+    }
+    sort.Strings(others)
+    for _, other := range others {
+        res.Warnings = append(res.Warnings, fmt.Sprintf("open PR %s also impacts %s", other, strings.Join(overlaps[other], ", ")))
+    }
+    return nil
+}
+
+// removedServersRule warns about servers dropped from an app's whitelists that another app, in
+// apps.json or any of the apps_files, still whitelists or blacklists
+func removedServersRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    if pc.PRAppsJson == nil || pc.BaseAppsJson == nil {
+        return nil
+    }
+    removed := removedWhitelistEntries(pc.PRAppsJson, pc.BaseAppsJson)
+    if len(removed) == 0 {
+        return nil
+    }
+    appsFiles := map[string]AppsJson{"apps.json": *pc.PRAppsJson}
+    for _, path := range config.AppsFiles {
+        if _, ok := appsFiles[path]; ok {
+            continue
+        }
+        data, err := pc.FileContent(ctx, path, pc.HeadRef())
+        if err != nil {
+            loggerFrom(ctx).Printf("Error fetching %s from PR branch: %v", path, err)
+            continue
+        }
+        var appsJson AppsJson
+        if err := json.Unmarshal(data, &appsJson); err != nil {
+            loggerFrom(ctx).Printf("Could not parse %s from PR branch: %v", path, err)
+            continue
+        }
+        appsFiles[path] = appsJson
+    }
+    // references maps each server to the "app (file)" entries that mention it
+    references := make(map[string][]string)
+    for _, path := range sortedFileNames(appsFiles) {
+        for _, app := range appsFiles[path].Apps {
+            seen := make(map[string]bool)
+            for _, s := range append(append([]string{}, app.Whitelists...), app.Blacklists...) {
+                if !seen[s] {
+                    seen[s] = true
+                    references[s] = append(references[s], fmt.Sprintf("%s (%s)", app.Name, path))
+                }
+            }
+        }
+    }
+    apps := make([]string, 0, len(removed))
+    for app := range removed {
+        apps = append(apps, app)
+    }
+    sort.Strings(apps)
+    for _, app := range apps {
+        self := fmt.Sprintf("%s (apps.json)", app)
+        for _, s := range removed[app] {
+            var others []string
+            for _, ref := range references[s] {
+                if ref != self {
+                    others = append(others, ref)
+                }
+            }
+            if len(others) > 0 {
+                res.Warnings = append(res.Warnings, fmt.Sprintf("%s was removed from app %s but is still referenced by %s", s, app, strings.Join(others, ", ")))
+            }
+        }
+    }
+    return nil
+}
+
+// sortedFileNames returns the paths of appsFiles in sorted order
+func sortedFileNames(appsFiles map[string]AppsJson) []string {
+    paths := make([]string, 0, len(appsFiles))
+    for path := range appsFiles {
+        paths = append(paths, path)
+    }
+    sort.Strings(paths)
+    return paths
+}
+
+// listConflictsRule fails PRs whose apps.json both whitelists and blacklists a server for an
+// app, or defines an app more than once
+func listConflictsRule(ctx context.Context, pc *prContext, res *ruleResult) error {
+    if pc.PRAppsJson == nil {
+        return nil
+    }
+    res.Failures = append(res.Failures, checkListConflicts(*pc.PRAppsJson)...)
+    return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "testing"
+)
+
+func TestRunRulesSkipsGitHubOnlyRulesForGitLab(t *testing.T) {
+    useConfig(t, Config{})
+    ran := map[string]bool{}
+    check := func(ctx context.Context, pc *prContext, res *ruleResult) error {
+        ran[res.Rule] = true
+        return nil
+    }
+    rs := []Rule{{Name: "portable", Check: check}, {Name: "reviews", Check: check, GitHubOnly: true}}
+
+    results := runRules(context.Background(), &prContext{GitLab: true}, rs)
+    if !ran["portable"] || ran["reviews"] {
+        t.Errorf("rules run for GitLab: %v, want only portable", ran)
+    }
+    if len(results) != 2 || !results[1].Skipped || results[1].SkipReason == "" {
+        t.Errorf("GitHub-only rule not reported as skipped: %+v", results)
+    }
+
+    ran = map[string]bool{}
+    runRules(context.Background(), &prContext{}, rs)
+    if !ran["portable"] || !ran["reviews"] {
+        t.Errorf("rules run for GitHub: %v, want both", ran)
+    }
+}
+
+func TestRunRulesSkipsUnconfiguredRules(t *testing.T) {
+    useConfig(t, Config{})
+    ran := false
+    rs := []Rule{{Name: "signed", Check: func(ctx context.Context, pc *prContext, res *ruleResult) error {
+        ran = true
+        return nil
+    }, Configured: func() bool { return config.RequireSignedCommits }}}
+
+    results := runRules(context.Background(), &prContext{}, rs)
+    if ran || len(results) != 1 || results[0].SkipReason != "not configured" {
+        t.Errorf("unconfigured rule ran: %t, results %+v", ran, results)
+    }
+    config.RequireSignedCommits = true
+    if runRules(context.Background(), &prContext{}, rs); !ran {
+        t.Error("configured rule did not run")
+    }
+}
+
+func TestAppNameUniquenessReadsHeadRef(t *testing.T) {
+    useConfig(t, Config{AppsFiles: []string{"apps.json", "more/apps.json"}})
+    details := &PRDetails{}
+    details.Head.SHA = "forkhead"
+    contents := map[string]string{
+        "apps.json@forkhead":      `{"apps":[{"name":"billing"}]}`,
+        "more/apps.json@forkhead": `{"apps":[{"name":"billing"}]}`,
+    }
+    pc := &prContext{
+        Number:  7,
+        Details: details,
+        Files:   []PRFile{{Filename: "more/apps.json"}},
+        fetchFile: func(ctx context.Context, path, ref string) ([]byte, error) {
+            if c, ok := contents[path+"@"+ref]; ok {
+                return []byte(c), nil
+            }
+            return nil, &githubError{StatusCode: 404}
+        },
+    }
+    var res ruleResult
+    if err := appNameUniquenessRule(context.Background(), pc, &res); err != nil || len(res.Failures) != 1 {
+        t.Errorf("got %v, %v; want the duplicate billing app read at the head SHA", res.Failures, err)
+    }
+
+    pc.fetchFile = func(ctx context.Context, path, ref string) ([]byte, error) {
+        return nil, errors.New("connection reset")
+    }
+    if err := appNameUniquenessRule(context.Background(), pc, &ruleResult{}); err == nil {
+        t.Error("an unreadable apps file passed the rule")
+    }
+}
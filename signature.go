@@ -0,0 +1,26 @@
+package main
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "strings"
+)
+
+// webhookSecret is the secret GitHub signs webhook deliveries with; empty skips verification
+var webhookSecret string
+
+// validSignature checks an X-Hub-Signature-256 header ("sha256=<hex>") against the
+// HMAC-SHA256 of body, comparing in constant time
+func validSignature(secret string, body []byte, header string) bool {
+    if !strings.HasPrefix(header, "sha256=") {
+        return false
+    }
+    got, err := hex.DecodeString(strings.TrimPrefix(header, "sha256="))
+    if err != nil {
+        return false
+    }
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write(body)
+    return hmac.Equal(got, mac.Sum(nil))
+}
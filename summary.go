@@ -0,0 +1,182 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// validationRecord is one validation of a PR
+type validationRecord struct {
+    Time        time.Time
+    Repo        string
+    PR          int
+    Status      string
+    FailedRules []string
+    Apps        []string
+}
+
+// validationStore keeps validations apart from the audit log, so they neither evict the
+// closes and reopens /admin/reopen replays nor grow the audit file. It holds the latest
+// status of up to maxPRs PRs, and the validations within retention for the summary.
+type validationStore struct {
+    mu        sync.Mutex
+    retention time.Duration
+    recent    []validationRecord
+    maxPRs    int
+    last      map[string]validationRecord
+}
+
+// validations is the process-wide validation store; summaries set its retention
+var validations = &validationStore{maxPRs: 10000, last: make(map[string]validationRecord)}
+
+// Record adds a validation, stamping it with the current time
+func (s *validationStore) Record(v validationRecord) {
+    v.Time = time.Now().UTC()
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.retention > 0 {
+        s.recent = append(s.recent, v)
+        i := 0
+        for i < len(s.recent) && v.Time.Sub(s.recent[i].Time) > s.retention {
+            i++
+        }
+        s.recent = s.recent[i:]
+    }
+    s.last[fmt.Sprintf("%s#%d", v.Repo, v.PR)] = v
+    if len(s.last) > s.maxPRs {
+        // Forget the PR validated longest ago
+        var oldest string
+        for k, r := range s.last {
+            if oldest == "" || r.Time.Before(s.last[oldest].Time) {
+                oldest = k
+            }
+        }
+        delete(s.last, oldest)
+    }
+}
+
+// Since returns the validations recorded at or after t, oldest first
+func (s *validationStore) Since(t time.Time) []validationRecord {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    var out []validationRecord
+    for _, v := range s.recent {
+        if !v.Time.Before(t) {
+            out = append(out, v)
+        }
+    }
+    return out
+}
+
+// LastStatus returns the status of the most recent validation of a PR, or "" when none is recorded
+func (s *validationStore) LastStatus(repo string, pr int) string {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.last[fmt.Sprintf("%s#%d", repo, pr)].Status
+}
+
+// recordValidation adds a validation to the validation store for reopen_action and the
+// periodic summary
+func recordValidation(pc *prContext, v validation) {
+    validationsTotal.Inc(v.Status)
+    rec := validationRecord{Repo: pc.Owner + "/" + pc.Repo, PR: pc.Number, Status: v.Status}
+    for _, res := range v.Results {
+        if len(res.Failures) > 0 {
+            rec.FailedRules = append(rec.FailedRules, res.Rule)
+        }
+    }
+    apps := make(map[string]bool)
+    for _, app := range pc.ChangedApps {
+        apps[app] = true
+    }
+    for app := range pc.AppServers {
+        apps[app] = true
+    }
+    rec.Apps = sortedKeys(apps)
+    validations.Record(rec)
+}
+
+// summaryText summarizes the validations over the past interval
+func summaryText(recs []validationRecord, interval time.Duration) string {
+    total := 0
+    statuses := make(map[string]int)
+    failedRules := make(map[string]int)
+    apps := make(map[string]int)
+    for _, rec := range recs {
+        total++
+        statuses[rec.Status]++
+        for _, r := range rec.FailedRules {
+            failedRules[r]++
+        }
+        for _, a := range rec.Apps {
+            apps[a]++
+        }
+    }
+    var b strings.Builder
+    fmt.Fprintf(&b, "commitvalidator summary for the last %s: %d validations, %d passed, %d failed, %d pending\n",
+        interval, total, statuses["success"]+statuses["neutral"], statuses["failure"], statuses["pending"])
+    if top := topCounts(failedRules, 5); top != "" {
+        fmt.Fprintf(&b, "Top failing rules: %s\n", top)
+    }
+    if top := topCounts(apps, 5); top != "" {
+        fmt.Fprintf(&b, "Most changed apps: %s\n", top)
+    }
+    return b.String()
+}
+
+// topCounts renders the n largest counts as "name (count)", largest first
+func topCounts(counts map[string]int, n int) string {
+    names := make([]string, 0, len(counts))
+    for name := range counts {
+        names = append(names, name)
+    }
+    sort.Slice(names, func(i, j int) bool {
+        if counts[names[i]] != counts[names[j]] {
+            return counts[names[i]] > counts[names[j]]
+        }
+        return names[i] < names[j]
+    })
+    if len(names) > n {
+        names = names[:n]
+    }
+    var parts []string
+    for _, name := range names {
+        parts = append(parts, fmt.Sprintf("%s (%d)", name, counts[name]))
+    }
+    return strings.Join(parts, ", ")
+}
+
+// startSummaries posts a summary every interval to a Slack-compatible webhook URL, or as a
+// comment on an issue when target is "owner/repo#number"
+func startSummaries(interval time.Duration, target string) error {
+    post := func(text string) error { return postChatMessage(target, text) }
+    if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+        var owner, repo string
+        var issue int
+        repoPath, num, ok := strings.Cut(target, "#")
+        if ok {
+            owner, repo, ok = strings.Cut(repoPath, "/")
+        }
+        if _, err := fmt.Sscanf(num, "%d", &issue); !ok || err != nil {
+            return fmt.Errorf("summary target %q is neither a URL nor owner/repo#number", target)
+        }
+        post = func(text string) error { return githubAPI.PostComment(context.Background(), owner, repo, issue, text) }
+    }
+    validations.mu.Lock()
+    validations.retention = interval
+    validations.mu.Unlock()
+    go func() {
+        for range time.Tick(interval) {
+            text := summaryText(validations.Since(time.Now().Add(-interval)), interval)
+            if err := post(text); err != nil {
+                log.Printf("Could not post validation summary: %v", err)
+            }
+        }
+    }()
+    return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestValidationStoreKeepsValidationsOutOfAudit(t *testing.T) {
+    savedAudit, savedValidations := audit, validations
+    audit = &auditStore{max: 10}
+    validations = &validationStore{retention: time.Hour, maxPRs: 2, last: make(map[string]validationRecord)}
+    t.Cleanup(func() { audit, validations = savedAudit, savedValidations })
+
+    audit.Record(auditEntry{Kind: auditClose, Repo: "o/r", PR: 1})
+    for i := 0; i < 20; i++ {
+        recordValidation(&prContext{Owner: "o", Repo: "r", Number: 1}, validation{Status: "failure"})
+    }
+    recordValidation(&prContext{Owner: "o", Repo: "r", Number: 2}, validation{Status: "success"})
+
+    if got := audit.Since(time.Time{}); len(got) != 1 || got[0].Kind != auditClose {
+        t.Errorf("audit entries = %+v, want only the close", got)
+    }
+    if got := validations.LastStatus("o/r", 1); got != "failure" {
+        t.Errorf("LastStatus(o/r#1) = %q, want failure", got)
+    }
+    if text := summaryText(validations.Since(time.Now().Add(-time.Hour)), time.Hour); !strings.Contains(text, "21 validations, 1 passed, 20 failed") {
+        t.Errorf("unexpected summary %q", text)
+    }
+
+    time.Sleep(time.Millisecond)
+    recordValidation(&prContext{Owner: "o", Repo: "r", Number: 3}, validation{Status: "success"})
+    if got := validations.LastStatus("o/r", 1); got != "" {
+        t.Errorf("LastStatus(o/r#1) = %q after exceeding maxPRs, want it evicted", got)
+    }
+}